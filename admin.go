@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// adminRealm is the realm advertised in WWW-Authenticate challenges.
+const adminRealm = "speedtest-tracker-webhook admin"
+
+// adminAuthEnabled reports whether admin credentials are configured, which
+// enables the /admin endpoints.
+func adminAuthEnabled() bool {
+	return settings.AdminToken != "" || settings.AdminUser != ""
+}
+
+// withAdminAuth requires the STW_ADMIN_TOKEN bearer token or the
+// STW_ADMIN_USER/STW_ADMIN_PASSWORD basic credentials, whichever are set.
+func withAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			if settings.AdminUser != "" {
+				w.Header().Add("WWW-Authenticate", `Basic realm="`+adminRealm+`", charset="UTF-8"`)
+			}
+			if settings.AdminToken != "" {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="`+adminRealm+`"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminAuthorized checks the request credentials in constant time.
+func adminAuthorized(r *http.Request) bool {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && settings.AdminToken != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(settings.AdminToken)) == 1
+	}
+	if user, password, ok := r.BasicAuth(); ok && settings.AdminUser != "" {
+		// Both are compared so a wrong user takes as long as a wrong password.
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(settings.AdminUser))
+		passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(settings.AdminPassword))
+		return userOK&passwordOK == 1
+	}
+	return false
+}
+
+type sinkStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// adminSinksHandler lists the configured sinks on GET and enables or disables
+// one on POST with a {"name": ..., "enabled": ...} body.
+func adminSinksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req sinkStatus
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Error parsing JSON body", http.StatusBadRequest)
+			return
+		}
+		s := findSink(req.Name)
+		if s == nil {
+			http.Error(w, "Unknown sink", http.StatusNotFound)
+			return
+		}
+		s.enabled.Store(req.Enabled)
+		logFrom(r.Context()).Infof("Sink %s enabled=%t via admin API", req.Name, req.Enabled)
+		if settings.SinkStateFile != "" {
+			if err := saveSinkState(settings.SinkStateFile); err != nil {
+				logFrom(r.Context()).Errorf("Could not persist sink state: %v", err)
+			}
+		}
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	out := make([]sinkStatus, 0, len(sinks))
+	for _, s := range sinks {
+		out = append(out, sinkStatus{Name: s.Name(), Enabled: s.enabled.Load()})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// replayRequest is the optional body of POST /admin/replay.
+type replayRequest struct {
+	// Sink limits the replay to one sink, which is sent to even when disabled.
+	Sink string `json:"sink"`
+	// Metrics also records the results in the speed histograms again.
+	Metrics bool `json:"metrics"`
+}
+
+type replayResponse struct {
+	Replayed int  `json:"replayed"`
+	Failed   int  `json:"failed"`
+	Metrics  bool `json:"metrics"`
+}
+
+// adminReplayHandler re-sends the buffered history to the sinks, oldest first.
+// Metrics are left alone unless requested, so a replay doesn't double-count.
+func adminReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var req replayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	var target *managedSink
+	if req.Sink != "" {
+		if target = findSink(req.Sink); target == nil {
+			http.Error(w, "Unknown sink", http.StatusNotFound)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	logger := logFrom(ctx)
+	out := replayResponse{Metrics: req.Metrics}
+	for _, res := range history.Snapshot() {
+		if target != nil {
+			if err := target.send(ctx, res); err != nil {
+				logger.Errorf("Sink %s failed to replay result %d: %v", target.Name(), res.Payload.ResultID, err)
+				out.Failed++
+				continue
+			}
+		} else {
+			sendToSinks(ctx, res)
+		}
+		if req.Metrics && res.Outcome == outcomeSuccess {
+			derived := append(geoAttributes(logger, res.Payload), tenantAttributes(res.Tenant)...)
+			attrs := metricAttributes(res.Payload, derived)
+			recordSpeedHistograms(ctx, res.Payload, metric.WithAttributes(attrs...))
+		}
+		out.Replayed++
+	}
+	logger.Infof("Replayed %d results (sink=%q, metrics=%t) via admin API", out.Replayed, req.Sink, req.Metrics)
+	writeJSON(w, http.StatusOK, out)
+}
+
+type resetResponse struct {
+	Reset []string `json:"reset"`
+}
+
+// adminResetHandler clears the in-memory state built from past results, for
+// testing dashboards without a restart. Each structure is cleared under its
+// own lock, so results arriving meanwhile land in either the old or the new
+// state. Exported metrics are cumulative in the backend and are not affected.
+func adminResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	out := resetResponse{Reset: []string{"last_seen", "identical", "baselines", "download_ranges", "good_streaks", "gauge_order", "alert_states", "last_received", "record_every_n"}}
+	serverLastSeen.reset()
+	identicalResults.reset()
+	serverBaselines.reset()
+	serverDownloadRanges.reset()
+	serverGoodStreaks.reset()
+	serverGaugeOrder.reset()
+	ruleAlertStates.reset()
+	lastReceivedAt.Store(0)
+	webhookCount.Store(0)
+	if history != nil {
+		history.Reset()
+		out.Reset = append([]string{"history"}, out.Reset...)
+	}
+	logFrom(r.Context()).Warnf("In-memory state reset via admin API: %s", strings.Join(out.Reset, ", "))
+	writeJSON(w, http.StatusOK, out)
+}
+
+// registerInternalOnlyRoutes adds the endpoints that are only served on the
+// internal STW_ADMIN_ADDR listener. With STW_ADMIN_TOKEN or STW_ADMIN_USER set
+// they require the admin credentials too.
+func registerInternalOnlyRoutes(mux *http.ServeMux) {
+	protect := func(h http.Handler) http.Handler {
+		if !adminAuthEnabled() {
+			return h
+		}
+		return withAdminAuth(h)
+	}
+	mux.Handle("/debug/pprof/", protect(http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", protect(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", protect(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", protect(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", protect(http.HandlerFunc(pprof.Trace)))
+	mux.Handle("/config", protect(http.HandlerFunc(configHandler)))
+	mux.Handle("/debug/vars", protect(expvar.Handler()))
+}
+
+// configHandler returns the effective settings with secrets redacted.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings.redacted())
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInternalOnlyRoutesRequireAdminCredentials(t *testing.T) {
+	for name, env := range map[string]map[string]string{
+		"token":      {"STW_ADMIN_TOKEN": "t0ken"},
+		"basic auth": {"STW_ADMIN_USER": "admin", "STW_ADMIN_PASSWORD": "pw"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			useSettings(t, env)
+			mux := http.NewServeMux()
+			registerInternalOnlyRoutes(mux)
+			for _, path := range []string{"/config", "/debug/vars", "/debug/pprof/", "/debug/pprof/cmdline"} {
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != http.StatusUnauthorized {
+					t.Errorf("GET %s without credentials: status = %d, want %d", path, rec.Code, http.StatusUnauthorized)
+				}
+			}
+			req := httptest.NewRequest(http.MethodGet, "/config", nil)
+			if settings.AdminToken != "" {
+				req.Header.Set("Authorization", "Bearer "+settings.AdminToken)
+			} else {
+				req.SetBasicAuth(settings.AdminUser, settings.AdminPassword)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Errorf("GET /config with credentials: status = %d, want %d", rec.Code, http.StatusOK)
+			}
+		})
+	}
+}
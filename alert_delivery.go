@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// alertMaxBackoff caps the wait between alert delivery attempts.
+const alertMaxBackoff = 30 * time.Second
+
+// deadLetter is a line of the STW_ALERT_DEAD_LETTER_FILE: an alert that could
+// not be delivered to a channel. Only the host of the channel URL is kept, and
+// no headers, since Slack, Discord and most webhook URLs are themselves the
+// credential.
+type deadLetter struct {
+	Channel  string    `json:"channel"`
+	Host     string    `json:"host"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+	Alert    alert     `json:"alert"`
+}
+
+// deadLetterMu serializes appends to the dead-letter file.
+var deadLetterMu sync.Mutex
+
+// alertDeliveries tracks the running deliverAlert calls, and alertShutdown is
+// canceled when shutdown starts so they stop retrying; see closeAlerts. It is
+// the only way a delivery's ctx is canceled.
+var (
+	alertDeliveries             sync.WaitGroup
+	alertShutdown, cancelAlerts = context.WithCancel(context.Background())
+)
+
+// startAlertDelivery runs deliverAlert in the background, detached from the
+// request ctx but canceled by shutdown.
+func startAlertDelivery(ctx context.Context, logger *log.Entry, name string, ch alertChannel, a alert, body []byte) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(alertShutdown, cancel)
+	alertDeliveries.Go(func() {
+		defer cancel()
+		defer stop()
+		deliverAlert(ctx, logger, name, ch, a, body)
+	})
+}
+
+// closeAlerts cancels the alert deliveries, so those waiting to retry are
+// written to the dead-letter file, and waits for them until ctx is done.
+func closeAlerts(ctx context.Context) {
+	cancelAlerts()
+	done := make(chan struct{})
+	go func() {
+		alertDeliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("Shutdown timed out waiting for alert deliveries")
+	}
+}
+
+// deliverAlert posts an encoded alert to a webhook channel, retrying failures
+// with exponential backoff up to STW_ALERT_RETRIES times. An alert that is
+// still undelivered, or whose ctx is canceled first, is logged and written to
+// the dead-letter file.
+func deliverAlert(ctx context.Context, logger *log.Entry, name string, ch alertChannel, a alert, body []byte) {
+	logger = logger.WithFields(log.Fields{"rule": a.Rule, "channel": name})
+	giveUp := func(attempt int, err error) {
+		logger.WithField("attempts", attempt).WithError(err).Errorf("Could not deliver alert %s to channel %s", a.Rule, name)
+		writeDeadLetter(logger, deadLetter{
+			Channel:  name,
+			Host:     urlHost(ch.URL),
+			Attempts: attempt,
+			Error:    err.Error(),
+			FailedAt: time.Now(),
+			Alert:    a,
+		})
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := postAlert(ctx, ch, body)
+		if err == nil {
+			if attempt > 1 {
+				logger.WithField("attempts", attempt).Info("Alert delivered after retries")
+			}
+			return
+		}
+		if ctx.Err() != nil {
+			giveUp(attempt, fmt.Errorf("%w; stopped retrying at shutdown", err))
+			return
+		}
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || attempt > settings.AlertRetries {
+			giveUp(attempt, err)
+			return
+		}
+		logger.WithFields(log.Fields{"attempt": attempt, "backoff": backoff.String()}).WithError(err).Warn("Alert delivery failed, retrying")
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			giveUp(attempt, fmt.Errorf("%w; stopped retrying at shutdown", err))
+			return
+		}
+		backoff = min(backoff*2, alertMaxBackoff)
+	}
+}
+
+// urlHost returns the host of a URL, or "" when it doesn't parse.
+func urlHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// postAlert makes a single delivery attempt. Network errors, 5xx and 429
+// responses are retryable; other rejections are final.
+func postAlert(ctx context.Context, ch alertChannel, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, alertClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "speedtest-tracker-webhook")
+	for k, v := range ch.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		// The channel URL is the credential; errors name its host only.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = urlHost(ch.URL)
+		}
+		return &retryableError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("channel rejected alert with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return &retryableError{err}
+	}
+	return err
+}
+
+// writeDeadLetter appends d as a JSON line to STW_ALERT_DEAD_LETTER_FILE, if set.
+func writeDeadLetter(logger *log.Entry, d deadLetter) {
+	if settings.AlertDeadLetterFile == "" {
+		return
+	}
+	line, err := json.Marshal(d)
+	if err != nil {
+		logger.Errorf("Could not encode dead letter: %v", err)
+		return
+	}
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	f, err := os.OpenFile(settings.AlertDeadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		logger.Errorf("Could not open dead-letter file: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		logger.Errorf("Could not write dead-letter file: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestShutdownDeadLettersRetryingAlerts(t *testing.T) {
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+	useSettings(t, map[string]string{"STW_ALERT_RETRIES": "5", "STW_ALERT_DEAD_LETTER_FILE": deadLetters})
+	prevShutdown, prevCancel := alertShutdown, cancelAlerts
+	alertShutdown, cancelAlerts = context.WithCancel(context.Background())
+	t.Cleanup(func() { alertShutdown, cancelAlerts = prevShutdown, prevCancel })
+
+	var attempts atomic.Int32
+	channel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer channel.Close()
+	ch := alertChannel{Type: "webhook", URL: channel.URL + "/services/T000/B000/secret-token"}
+
+	startAlertDelivery(context.Background(), log.NewEntry(log.StandardLogger()), "slack", ch, alert{Rule: "slow"}, []byte(`{}`))
+	for attempts.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	closeAlerts(ctx)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("closeAlerts took %s, want the backoff cut short", elapsed)
+	}
+
+	raw, err := os.ReadFile(deadLetters)
+	if err != nil {
+		t.Fatalf("dead-letter file: %v", err)
+	}
+	if strings.Contains(string(raw), "secret-token") {
+		t.Errorf("dead letter contains the channel URL: %s", raw)
+	}
+	var d deadLetter
+	if err := json.Unmarshal(raw, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Channel != "slack" || d.Host != strings.TrimPrefix(channel.URL, "http://") || d.Alert.Rule != "slow" {
+		t.Errorf("dead letter = %+v", d)
+	}
+	if !strings.Contains(d.Error, "stopped retrying") {
+		t.Errorf("dead letter error = %q, want the shutdown noted", d.Error)
+	}
+}
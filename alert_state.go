@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// alertStateMaxEntries bounds how many rule and server pairs the firing state
+// is kept for; further pairs fire on every breach and never resolve.
+const alertStateMaxEntries = 10000
+
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+type alertStateKey struct {
+	rule string
+	lastSeenKey
+}
+
+type alertState struct {
+	firing    bool
+	firedAt   time.Time
+	lastFired time.Time
+}
+
+// alertStates tracks, per rule and server, whether a rule is firing, so breaches
+// within STW_ALERT_COOLDOWN of the last notification are suppressed and the
+// first result that no longer breaches sends a single recovery. The state
+// lives in memory only and survives rule reloads by rule name.
+type alertStates struct {
+	mu      sync.Mutex
+	entries map[alertStateKey]*alertState
+}
+
+var ruleAlertStates = &alertStates{entries: make(map[alertStateKey]*alertState)}
+
+// breached records a breach of rule by p's server at now. It returns whether
+// to notify and, for a breach continuing an earlier one, when that started.
+func (a *alertStates) breached(rule, tenant string, p WebhookPayload, now time.Time) (notify bool, since time.Time) {
+	key := alertStateKey{rule, lastSeenKey{tenant, p.ServerID}}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.entries[key]
+	if !ok {
+		if len(a.entries) >= alertStateMaxEntries {
+			return true, now
+		}
+		s = &alertState{}
+		a.entries[key] = s
+	}
+	if !s.firing {
+		s.firing, s.firedAt, s.lastFired = true, now, now
+		return true, now
+	}
+	if settings.AlertCooldown > 0 && now.Sub(s.lastFired) < settings.AlertCooldown {
+		return false, s.firedAt
+	}
+	s.lastFired = now
+	return true, s.firedAt
+}
+
+// cleared records that p's server no longer breaches rule. It reports whether
+// the rule was firing, and since when, so a recovery is due.
+func (a *alertStates) cleared(rule, tenant string, p WebhookPayload) (recovered bool, since time.Time) {
+	key := alertStateKey{rule, lastSeenKey{tenant, p.ServerID}}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.entries[key]
+	if !ok || !s.firing {
+		return false, time.Time{}
+	}
+	delete(a.entries, key)
+	return true, s.firedAt
+}
+
+// reset forgets every firing rule, without sending recoveries.
+func (a *alertStates) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clear(a.entries)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertStatesCooldownAndRecovery(t *testing.T) {
+	useSettings(t, map[string]string{"STW_ALERT_COOLDOWN": "1h"})
+	a := &alertStates{entries: make(map[alertStateKey]*alertState)}
+	p := WebhookPayload{ServerID: 7}
+	start := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+	if notify, since := a.breached("slow", "", p, start); !notify || !since.Equal(start) {
+		t.Fatalf("first breach: notify %t since %s", notify, since)
+	}
+	if notify, _ := a.breached("slow", "", p, start.Add(10*time.Minute)); notify {
+		t.Error("breach within the cooldown was notified")
+	}
+	if notify, _ := a.breached("slow", "other", p, start.Add(10*time.Minute)); !notify {
+		t.Error("breach by another tenant's server was suppressed")
+	}
+	if notify, since := a.breached("slow", "", p, start.Add(2*time.Hour)); !notify || !since.Equal(start) {
+		t.Errorf("breach after the cooldown: notify %t since %s", notify, since)
+	}
+
+	if recovered, since := a.cleared("slow", "", p); !recovered || !since.Equal(start) {
+		t.Errorf("recovery: recovered %t since %s", recovered, since)
+	}
+	if recovered, _ := a.cleared("slow", "", p); recovered {
+		t.Error("second good result sent another recovery")
+	}
+	if notify, _ := a.breached("slow", "", p, start.Add(3*time.Hour)); !notify {
+		t.Error("breach after recovery was suppressed")
+	}
+}
+
+func TestAlertStatesWithoutCooldown(t *testing.T) {
+	useSettings(t, nil)
+	a := &alertStates{entries: make(map[alertStateKey]*alertState)}
+	p := WebhookPayload{ServerID: 7}
+	now := time.Now()
+	for i := range 3 {
+		if notify, _ := a.breached("slow", "", p, now.Add(time.Duration(i)*time.Second)); !notify {
+			t.Errorf("breach %d was suppressed without STW_ALERT_COOLDOWN", i)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// renameableAttributes are the result attribute keys STW_ATTRIBUTE_RENAMES can
+// rename, as recorded on metrics and span events by default.
+var renameableAttributes = []string{
+	"server.id",
+	"server.name",
+	"isp",
+	"connection.type",
+	"network.interface.name",
+	"speedtest.schedule",
+	"server.country",
+	"server.location",
+	"geo.country.iso_code",
+	"geo.locality.name",
+	"tenant",
+	"client",
+}
+
+// parseAttributeRenames validates the "from=to" pairs of STW_ATTRIBUTE_RENAMES.
+// Every source must be a renameable attribute, and a new key must not be used
+// twice, by a static attribute, or by an attribute that keeps its name.
+func parseAttributeRenames(pairs map[string]string, static []attribute.KeyValue) (map[attribute.Key]attribute.Key, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	renames := make(map[attribute.Key]attribute.Key, len(pairs))
+	owner := make(map[string]string, len(pairs))
+	for from, to := range pairs {
+		if !slices.Contains(renameableAttributes, from) {
+			return nil, fmt.Errorf("invalid value for env var STW_ATTRIBUTE_RENAMES: unknown attribute %q, expected one of %s", from, strings.Join(renameableAttributes, ", "))
+		}
+		if to == "" {
+			return nil, fmt.Errorf("invalid value for env var STW_ATTRIBUTE_RENAMES: empty new name for %q", from)
+		}
+		if other, ok := owner[to]; ok {
+			return nil, fmt.Errorf("invalid value for env var STW_ATTRIBUTE_RENAMES: %q and %q are both renamed to %q", other, from, to)
+		}
+		owner[to] = from
+		renames[attribute.Key(from)] = attribute.Key(to)
+	}
+	for to, from := range owner {
+		if _, renamed := pairs[to]; slices.Contains(renameableAttributes, to) && !renamed {
+			return nil, fmt.Errorf("invalid value for env var STW_ATTRIBUTE_RENAMES: %q is renamed to %q, which is already an attribute", from, to)
+		}
+		for _, a := range static {
+			if string(a.Key) == to {
+				return nil, fmt.Errorf("invalid value for env var STW_ATTRIBUTE_RENAMES: %q is renamed to %q, which is a STW_STATIC_METRIC_ATTRIBUTES key", from, to)
+			}
+		}
+	}
+	return renames, nil
+}
+
+// renameAttributes applies STW_ATTRIBUTE_RENAMES to attrs in place and
+// returns them.
+func renameAttributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	if len(settings.AttributeRenames) == 0 {
+		return attrs
+	}
+	for i, a := range attrs {
+		if to, ok := settings.AttributeRenames[a.Key]; ok {
+			attrs[i].Key = to
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// baselineMaxServers bounds how many servers get a rolling baseline.
+const baselineMaxServers = 1000
+
+// baselineMetric is a result value tracked against its baseline. Worse is the
+// direction of a degradation: -1 when lower is worse, 1 when higher is worse.
+type baselineMetric struct {
+	Name  string
+	Value func(p WebhookPayload) float64
+	Worse float64
+}
+
+var baselineMetrics = []baselineMetric{
+	{"download", func(p WebhookPayload) float64 { return float64(p.Download) / settings.SpeedUnit.Divisor }, -1},
+	{"upload", func(p WebhookPayload) float64 { return float64(p.Upload) / settings.SpeedUnit.Divisor }, -1},
+	{"ping", func(p WebhookPayload) float64 { return float64(p.Ping) }, 1},
+}
+
+// baselines keeps, per tenant and server ID, the last STW_BASELINE_WINDOW
+// values of each baseline metric.
+type baselines struct {
+	mu      sync.Mutex
+	servers map[lastSeenKey]map[string][]float64
+}
+
+var serverBaselines = &baselines{servers: make(map[lastSeenKey]map[string][]float64)}
+
+// observe compares p with its server's baseline, records the deviation and
+// fires alerts, then adds p to the window. Nothing is recorded until the
+// window is full.
+func (b *baselines) observe(ctx context.Context, tenant string, p WebhookPayload, attrs []attribute.KeyValue) {
+	window := settings.BaselineWindow
+	th := thresholds()
+	key := lastSeenKey{tenant, p.ServerID}
+
+	b.mu.Lock()
+	windows, ok := b.servers[key]
+	if !ok {
+		if len(b.servers) >= baselineMaxServers {
+			b.mu.Unlock()
+			return
+		}
+		windows = make(map[string][]float64)
+		b.servers[key] = windows
+	}
+	type deviation struct {
+		m         baselineMetric
+		value     float64
+		median    float64
+		percent   float64
+		threshold float64
+	}
+	var deviations []deviation
+	for _, m := range baselineMetrics {
+		v := m.Value(p)
+		values := windows[m.Name]
+		if len(values) == window {
+			median := medianOf(values)
+			if median != 0 {
+				deviations = append(deviations, deviation{
+					m:         m,
+					value:     v,
+					median:    median,
+					percent:   (v - median) / median * 100,
+					threshold: median * (1 + m.Worse*th.DeviationPercent/100),
+				})
+			}
+			values = values[1:]
+		}
+		windows[m.Name] = append(values, v)
+	}
+	b.mu.Unlock()
+
+	for _, d := range deviations {
+		deviationGauge.Record(ctx, d.percent, metric.WithAttributes(append(attrs, attribute.String("metric", d.m.Name))...))
+		// A degradation is a deviation in the metric's worse direction.
+		if !th.DeviationAlert || d.m.Worse*d.percent <= th.DeviationPercent {
+			continue
+		}
+		op := "<"
+		if d.m.Worse > 0 {
+			op = ">"
+		}
+		alertsCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("rule", "baseline-deviation"),
+			attribute.String("severity", "warning"),
+		))
+		dispatchAlert(ctx, logChannel, alertChannel{}, alert{
+			Rule:       "baseline-deviation",
+			Severity:   "warning",
+			Metric:     d.m.Name,
+			Operator:   op,
+			Threshold:  d.threshold,
+			Value:      d.value,
+			ResultID:   p.ResultID,
+			SiteName:   p.SiteName,
+			ServerID:   p.ServerID,
+			ServerName: p.ServerName,
+			RequestID:  requestIDFrom(ctx),
+			FiredAt:    time.Now(),
+		})
+	}
+}
+
+// reset empties every server's window.
+func (b *baselines) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.servers)
+}
+
+// medianOf returns the median of values without modifying them.
+func medianOf(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
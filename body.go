@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// bodyBuffers reuses request body buffers to reduce allocations under load.
+// Buffers never grow beyond STW_MAX_BODY_BYTES since bodies are capped at that size.
+var bodyBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// errInvalidGzip marks a Content-Encoding: gzip body that fails to decompress.
+var errInvalidGzip = errors.New("invalid gzip body")
+
+// readBody reads the request body, capped at STW_MAX_BODY_BYTES, into a pooled
+// buffer. Gzip bodies are decompressed, with the cap applying to both the
+// compressed and decompressed size; other encodings yield an
+// *unsupportedMediaError. The returned bytes are only valid until release is called.
+func readBody(w http.ResponseWriter, r *http.Request) (body []byte, release func(), err error) {
+	src, closeBody, err := openBody(w, r, settings.MaxBodyBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeBody()
+	return bufferBody(src)
+}
+
+// openBody returns the request body as readBody would read it, capped at limit
+// instead, for callers that consume it incrementally. closeBody must be called
+// when done.
+func openBody(w http.ResponseWriter, r *http.Request, limit int64) (src io.Reader, closeBody func(), err error) {
+	src = http.MaxBytesReader(w, r.Body, limit)
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return src, func() {}, nil
+	case "gzip":
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if !errors.As(err, &tooLarge) {
+				err = fmt.Errorf("%w: %v", errInvalidGzip, err)
+			}
+			return nil, nil, err
+		}
+		return gzipErrReader{http.MaxBytesReader(w, zr, limit)}, func() { zr.Close() }, nil
+	default:
+		return nil, nil, &unsupportedMediaError{What: "Content-Encoding " + enc}
+	}
+}
+
+// gzipErrReader marks read errors of a decompressed body as errInvalidGzip,
+// except for the size cap.
+type gzipErrReader struct {
+	r io.Reader
+}
+
+func (g gzipErrReader) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	var tooLarge *http.MaxBytesError
+	if err != nil && err != io.EOF && !errors.As(err, &tooLarge) {
+		err = fmt.Errorf("%w: %v", errInvalidGzip, err)
+	}
+	return n, err
+}
+
+// bufferBody reads src into a pooled buffer. The returned bytes are only valid
+// until release is called.
+func bufferBody(src io.Reader) (body []byte, release func(), err error) {
+	buf := bodyBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	release = func() { bodyBuffers.Put(buf) }
+
+	if _, err := buf.ReadFrom(src); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return buf.Bytes(), release, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// benchmarkBodies are a single result and a batch of about 64 KiB.
+var benchmarkBodies = map[string][]byte{
+	"single": []byte(testPayload),
+	"batch":  []byte("[" + strings.TrimSuffix(strings.Repeat(testPayload+",", 64*1024/len(testPayload)), ",") + "]"),
+}
+
+// BenchmarkReadBody reads bodies into the pooled buffers, as webhookHandler does.
+func BenchmarkReadBody(b *testing.B) {
+	useSettings(b, nil)
+	for name, body := range benchmarkBodies {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for b.Loop() {
+				r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+				got, release, err := readBody(httptest.NewRecorder(), r)
+				if err != nil || len(got) != len(body) {
+					b.Fatalf("read %d bytes: %v", len(got), err)
+				}
+				release()
+			}
+		})
+	}
+}
+
+// BenchmarkReadBodyReadAll is the io.ReadAll baseline the pooled buffers replaced.
+func BenchmarkReadBodyReadAll(b *testing.B) {
+	useSettings(b, nil)
+	for name, body := range benchmarkBodies {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for b.Loop() {
+				r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+				got, err := io.ReadAll(http.MaxBytesReader(httptest.NewRecorder(), r.Body, settings.MaxBodyBytes))
+				if err != nil || len(got) != len(body) {
+					b.Fatalf("read %d bytes: %v", len(got), err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadBodyLimit(t *testing.T) {
+	useSettings(t, map[string]string{"STW_MAX_BODY_BYTES": "16"})
+	r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(testPayload))
+	if _, _, err := readBody(httptest.NewRecorder(), r); err == nil {
+		t.Fatal("expected a body over STW_MAX_BODY_BYTES to fail")
+	} else if status, _ := bodyErrorResponse(err); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", status, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// bufferbloatGrades are ordered from best to worst; the last one has no upper bound.
+var bufferbloatGrades = []string{"A", "B", "C", "D", "F"}
+
+// defaultBufferbloatThresholds are the upper bounds, in ms of added latency under load,
+// for the grades A to D.
+var defaultBufferbloatThresholds = []float64{30, 60, 200, 400}
+
+// parseBufferbloatThresholds parses STW_BUFFERBLOAT_THRESHOLDS, four increasing
+// comma-separated millisecond bounds for the grades A to D.
+func parseBufferbloatThresholds(raw string) ([]float64, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return defaultBufferbloatThresholds, nil
+	}
+	parts := strings.Split(raw, ",")
+	if len(parts) != len(bufferbloatGrades)-1 {
+		return nil, fmt.Errorf("invalid value for env var STW_BUFFERBLOAT_THRESHOLDS %s: expected %d values", raw, len(bufferbloatGrades)-1)
+	}
+	thresholds := make([]float64, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || v < 0 || (i > 0 && v <= thresholds[i-1]) {
+			return nil, fmt.Errorf("invalid value for env var STW_BUFFERBLOAT_THRESHOLDS %s: values must be increasing non-negative numbers", raw)
+		}
+		thresholds[i] = v
+	}
+	return thresholds, nil
+}
+
+// bufferbloatGrade grades the worst latency increase under load over the idle ping.
+// It returns false when the payload carries no loaded latency.
+func bufferbloatGrade(p WebhookPayload, thresholds []float64) (grade string, score int64, ok bool) {
+	if p.DownloadLatency == nil && p.UploadLatency == nil {
+		return "", 0, false
+	}
+	var loaded float64
+	if p.DownloadLatency != nil {
+		loaded = float64(*p.DownloadLatency)
+	}
+	if p.UploadLatency != nil && float64(*p.UploadLatency) > loaded {
+		loaded = float64(*p.UploadLatency)
+	}
+	increase := max(loaded-float64(p.Ping), 0)
+
+	i := 0
+	for i < len(thresholds) && increase >= thresholds[i] {
+		i++
+	}
+	// Scores run from 4 (A) down to 0 (F).
+	return bufferbloatGrades[i], int64(len(bufferbloatGrades) - 1 - i), true
+}
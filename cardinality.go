@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// cardinalityTracker counts the distinct values seen per attribute key since
+// the last report.
+type cardinalityTracker struct {
+	mu     sync.Mutex
+	values map[attribute.Key]map[string]struct{}
+}
+
+// cardinality is set when STW_CARDINALITY_REPORT_INTERVAL is configured.
+var cardinality *cardinalityTracker
+
+func newCardinalityTracker() *cardinalityTracker {
+	return &cardinalityTracker{values: make(map[attribute.Key]map[string]struct{})}
+}
+
+// Observe records the values of attrs.
+func (c *cardinalityTracker) Observe(attrs ...attribute.KeyValue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, a := range attrs {
+		seen, ok := c.values[a.Key]
+		if !ok {
+			seen = make(map[string]struct{})
+			c.values[a.Key] = seen
+		}
+		seen[a.Value.Emit()] = struct{}{}
+	}
+}
+
+// reset returns the distinct value count per key and starts a new interval.
+func (c *cardinalityTracker) reset() log.Fields {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fields := make(log.Fields, len(c.values))
+	for k, seen := range c.values {
+		fields[string(k)] = len(seen)
+	}
+	c.values = make(map[attribute.Key]map[string]struct{})
+	return fields
+}
+
+// Report logs the distinct value counts every interval until ctx is done.
+func (c *cardinalityTracker) Report(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fields := c.reset()
+			if len(fields) == 0 {
+				continue
+			}
+			log.WithFields(fields).WithField("interval", interval.String()).Info("Distinct attribute values seen")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
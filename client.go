@@ -0,0 +1,72 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// clientOther is the client attribute for unrecognised User-Agents.
+const clientOther = "other"
+
+// clientMaxValues bounds the distinct client values, versions included.
+const clientMaxValues = 20
+
+// knownClients maps a User-Agent product token (lower case) to its client
+// name. Speedtest Tracker posts through Laravel's HTTP client, which sends
+// Guzzle's User-Agent unless the sender overrides it.
+var knownClients = map[string]string{
+	"speedtest-tracker": "speedtest-tracker",
+	"guzzlehttp":        "guzzle",
+	"curl":              "curl",
+	"uptime-kuma":       "uptime-kuma",
+	"go-http-client":    "go",
+	"python-requests":   "python-requests",
+	"postmanruntime":    "postman",
+	"wget":              "wget",
+}
+
+// clientProduct matches the leading product/version token of a User-Agent.
+var clientProduct = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9._-]*)(?:/v?(\d+))?`)
+
+// seenClients holds the client values handed out so far, up to clientMaxValues.
+var seenClients = struct {
+	mu     sync.Mutex
+	values map[string]bool
+}{values: make(map[string]bool)}
+
+// clientOf normalises a User-Agent into a known client name with its major
+// version, e.g. "guzzle/7", or "other".
+func clientOf(userAgent string) string {
+	m := clientProduct.FindStringSubmatch(strings.TrimSpace(userAgent))
+	if m == nil {
+		return clientOther
+	}
+	name, ok := knownClients[strings.ToLower(m[1])]
+	if !ok {
+		return clientOther
+	}
+	if m[2] != "" {
+		name += "/" + m[2]
+	}
+
+	seenClients.mu.Lock()
+	defer seenClients.mu.Unlock()
+	if !seenClients.values[name] {
+		if len(seenClients.values) >= clientMaxValues {
+			return clientOther
+		}
+		seenClients.values[name] = true
+	}
+	return name
+}
+
+// clientAttributes returns the client attribute when STW_RECORD_CLIENT is set.
+func clientAttributes(client string) []attribute.KeyValue {
+	if client == "" {
+		return nil
+	}
+	return []attribute.KeyValue{attribute.String("client", client)}
+}
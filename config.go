@@ -0,0 +1,909 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr/vm"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Settings holds the optional STW_* runtime options read from the environment.
+type Settings struct {
+	// Environment is attached as the deployment.environment resource attribute when set.
+	Environment string
+	// HistorySize is the number of recent results kept in memory; 0 disables the history.
+	HistorySize int
+	// RecordEveryN records metrics for only every Nth webhook; 1 records all of them.
+	RecordEveryN int
+	// DashboardEnabled serves the embedded HTML dashboard at /.
+	DashboardEnabled bool
+	// OTLPDestinations fans telemetry out to several backends instead of the OTEL_EXPORTER_OTLP_* one.
+	OTLPDestinations []otlpDestination
+	// OTLPCompression is the default OTLP compression: "gzip", "none", or empty
+	// for the exporter default.
+	OTLPCompression string
+	// OTLPMaxFailures is how many consecutive failed exports make an OTLP
+	// exporter get recreated; 0 disables the watchdog.
+	OTLPMaxFailures int
+	// OTLPRecreateCooldown is the least time between recreations of an exporter.
+	OTLPRecreateCooldown time.Duration
+	// OTLPKeepaliveTime is the idle time after which OTLP connections are
+	// probed; 0 keeps the exporters' default TCP keepalive.
+	OTLPKeepaliveTime time.Duration
+	// OTLPKeepaliveTimeout is how long a probe may go unanswered.
+	OTLPKeepaliveTimeout time.Duration
+	// OTLPHeaders are static headers sent to every OTLP destination.
+	OTLPHeaders map[string]string
+	// SpeedUnit is the unit download and upload speeds are recorded in.
+	SpeedUnit speedUnit
+	// FailureHeuristics selects how failed tests are detected.
+	FailureHeuristics failureHeuristics
+	// DrainDelay is how long /readyz reports unready before the server shuts down.
+	DrainDelay time.Duration
+	// StaticMetricAttributes are added to every recorded speedtest metric, but not to spans.
+	StaticMetricAttributes []attribute.KeyValue
+	// AttributeRenames maps default result attribute keys to the keys recorded
+	// on metrics and span events instead.
+	AttributeRenames map[attribute.Key]attribute.Key
+	// NoExport skips the OpenTelemetry SDK setup, leaving no-op telemetry, for
+	// load testing the HTTP and parsing path on its own.
+	NoExport bool
+	// ResponseHeaders are set on every HTTP response.
+	ResponseHeaders map[string]string
+	// AllowedServices lists the lower-cased result services that are recorded;
+	// nil records all of them.
+	AllowedServices map[string]bool
+	// ServerNameAliases maps lower-cased server names to the name recorded instead.
+	ServerNameAliases map[string]string
+	// ServerNameNormalize title-cases server names that have no alias.
+	ServerNameNormalize bool
+	// Statsd enables the StatsD sink when its address is set.
+	Statsd statsdConfig
+	// RemoteWrite enables the Prometheus remote-write sink when its URL is set.
+	RemoteWrite remoteWriteConfig
+	// SpanName names the webhook handling span.
+	SpanName string
+	// SpanAttributes are added to the webhook handling span.
+	SpanAttributes []attribute.KeyValue
+	// SpanHTTPMetadata adds the user agent and content length to the webhook span.
+	SpanHTTPMetadata bool
+	// TrackerAPIURL and TrackerAPIToken enable /run-test when both are set.
+	TrackerAPIURL   string
+	TrackerAPIToken string
+	// ExportGate holds recording until the first metric export succeeds, for at most ExportGateTimeout.
+	ExportGate        bool
+	ExportGateTimeout time.Duration
+	// AdminToken is the bearer token required by the /admin endpoints, which are disabled without it.
+	AdminToken string
+	// AdminUser and AdminPassword are HTTP basic credentials accepted by the
+	// /admin endpoints; they also protect the internal-only routes.
+	AdminUser     string
+	AdminPassword string
+	// AllowReset enables POST /admin/reset, which clears the in-memory state.
+	AllowReset bool
+	// SinkStateFile persists sink enable/disable changes across restarts when set.
+	SinkStateFile string
+	// MaxBodyBytes caps the size of webhook request bodies.
+	MaxBodyBytes int64
+	// StrictJSON rejects payloads containing fields that WebhookPayload doesn't model.
+	StrictJSON bool
+	// AdminAddr is the address of the internal listener; /webhook stays on the main port.
+	AdminAddr string
+	// MaxResultAge skips results whose timestamp is older than this; 0 disables the check.
+	MaxResultAge time.Duration
+	// ServerLocationMetricAttributes also adds the server location and country to metrics.
+	ServerLocationMetricAttributes bool
+	// FieldMap maps payload fields to JSON paths in custom webhook bodies.
+	FieldMap map[string][]string
+	// OtelRequired makes an OpenTelemetry setup failure fatal. When false, the server
+	// runs with no-op telemetry and retries the setup every OtelRetryInterval.
+	OtelRequired      bool
+	OtelRetryInterval time.Duration
+	// ConnectionType is attached as connection.type unless the payload sets connectionType.
+	ConnectionType string
+	// CORSOrigins lists the origins allowed to call /webhook and /results from a
+	// browser; "*" allows any. Empty disables CORS.
+	CORSOrigins []string
+	// TLSCertFile and TLSKeyFile enable HTTPS on the listeners when both are set.
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSMinVersion uint16
+	// TransformExpr is STW_TRANSFORM_EXPR and Transform its compiled form, applied
+	// to every payload after parsing; nil when unset.
+	TransformExpr string
+	Transform     *vm.Program `json:"-"`
+	// PerSiteMeters records the speedtest histograms of each SiteName on its own
+	// meter, with the site as the metric name prefix. MaxSites bounds how many
+	// sites get one; later sites share the default histograms.
+	PerSiteMeters bool
+	MaxSites      int
+	// WebhookSecret, when set, requires an HMAC-SHA256 signature of the body in
+	// the SignatureHeader request header, encoded as SignatureFormat.
+	WebhookSecret   string
+	SignatureHeader string
+	SignatureFormat string
+	// AuthQueryParam, when set, requires AuthQueryToken in that query parameter
+	// of /webhook requests.
+	AuthQueryParam string
+	AuthQueryToken string
+	// LogLevel is the minimum level written to the logs.
+	LogLevel log.Level
+	// LogColor is always, auto (color on a TTY) or never.
+	LogColor string
+	// LogFile is where logs are written instead of stderr; it is reopened on SIGHUP.
+	LogFile string
+	// LogRawBody logs webhook bodies at debug level, redacted and truncated to
+	// LogRawBodyMax bytes.
+	LogRawBody    bool
+	LogRawBodyMax int
+	// RuntimeMetrics exports Go runtime metrics (goroutines, memory, GC).
+	RuntimeMetrics bool
+	// MissingPacketLossZero treats an omitted packetLoss as 0% instead of leaving
+	// it unrecorded.
+	MissingPacketLossZero bool
+	// ValuePrecision is the number of decimals recorded ping, download and upload
+	// values are rounded to, after unit conversion; -1 disables rounding.
+	ValuePrecision int
+	// GeoIPDB is the path of a MaxMind City or Country database used to locate
+	// the payload's publicIp.
+	GeoIPDB string
+	// GoodStreakTier is the lowest tier that extends speedtest.good_streak.
+	GoodStreakTier string
+	// GaugeWriteOrder is gaugeOrderArrival or gaugeOrderTimestamp, the order
+	// concurrent results write the last-value gauges in.
+	GaugeWriteOrder string
+	// CardinalityReportInterval is how often the distinct metric attribute values
+	// seen are logged; 0 disables the report.
+	CardinalityReportInterval time.Duration
+	// PrettyJSON indents JSON response bodies.
+	PrettyJSON bool
+	// RulesFile is the YAML file of alert rules evaluated against every result.
+	RulesFile string
+	// AlertRetries is how many times a failed alert delivery is retried.
+	AlertRetries int
+	// AlertCooldown is how long repeated breaches of a rule by a server are
+	// suppressed after a notification; 0 notifies every breach.
+	AlertCooldown time.Duration
+	// AlertDeadLetterFile receives alerts that could not be delivered, as JSON lines.
+	AlertDeadLetterFile string
+	// ConfigFile is the optional YAML config file; STW_* env vars take precedence over it.
+	ConfigFile string
+	// ThresholdsFile holds KEY=value lines for the threshold env vars; they
+	// override the environment and are re-read on SIGHUP.
+	ThresholdsFile string
+	// thresholdSettings are the values at startup; read them with thresholds(),
+	// which a SIGHUP reload swaps.
+	thresholdSettings
+	// BaselineWindow is how many recent results per server form the baseline
+	// that deviations are measured against; 0 disables baselines.
+	BaselineWindow int
+	// DownloadRangeWindow is how far back speedtest.download.min/max look; 0
+	// disables the gauges.
+	DownloadRangeWindow time.Duration
+	// Tenants, when set, is the allowlist of /webhook/{tenant} path segments.
+	Tenants map[string]bool
+	// RejectTrailingData rejects payloads with anything after the JSON object.
+	RejectTrailingData bool
+	// StaleAfter makes /status report stale when no webhook arrived for this long; 0 disables it.
+	StaleAfter time.Duration
+	// ForwardTargets are downstream webhooks every result is re-posted to.
+	ForwardTargets []forwardTarget
+	// SinkTimeout bounds each delivery of a result to a sink.
+	SinkTimeout time.Duration
+	// ForwardSecret signs forwarded bodies for targets without their own secret.
+	ForwardSecret string
+	// SuppressIdenticalWindow skips recording results that repeat the last
+	// recorded values of their server within this window; 0 disables it.
+	SuppressIdenticalWindow time.Duration
+	// SuppressIdenticalTolerance is how far apart, in percent, values may be and
+	// still count as identical.
+	SuppressIdenticalTolerance float64
+	// ExtraNumericFields records additional numeric payload fields as histograms.
+	ExtraNumericFields []extraNumericField
+	// MaxSpanEvents caps the result events added to a webhook span.
+	MaxSpanEvents int
+	// FlushPerRequest force-flushes metrics after every webhook.
+	FlushPerRequest bool
+	// SlowRequestThreshold logs webhook requests taking longer, with the time
+	// of each phase; 0 disables it.
+	SlowRequestThreshold time.Duration
+	// WebhookHead answers HEAD requests to the webhook with 200 instead of 405.
+	WebhookHead bool
+	// StreamBatchBytes is the body size above which batches are decoded as a
+	// stream; 0 always buffers them. MaxStreamBytes caps streamed bodies in
+	// place of MaxBodyBytes.
+	StreamBatchBytes int64
+	MaxStreamBytes   int64
+	// MaxBatchSize caps the results taken from a batch; 0 means no limit.
+	MaxBatchSize int
+	// MaxBatchTruncate records the first MaxBatchSize results of a larger
+	// batch instead of rejecting it.
+	MaxBatchTruncate bool
+	// RecordClient adds a normalised User-Agent as the client attribute.
+	RecordClient bool
+	// JSONL enables the JSON-lines file sink when its path is set.
+	JSONL jsonlConfig
+	// PlanDownloadMbps and PlanUploadMbps are the advertised plan speeds results
+	// are compared with; 0 skips the comparison.
+	PlanDownloadMbps float64
+	PlanUploadMbps   float64
+	// QualityScore records speedtest.quality_score, weighting each component
+	// by QualityScoreWeights and normalizing it against QualityScoreTargets.
+	QualityScore        bool
+	QualityScoreWeights qualityScoreFactors
+	QualityScoreTargets qualityScoreFactors
+}
+
+// speedUnit converts the bits per second reported by Speedtest Tracker into another unit.
+type speedUnit struct {
+	// OtelUnit is the unit reported on the histograms.
+	OtelUnit string
+	// Divisor converts a value in bits per second into this unit.
+	Divisor float64
+}
+
+// speedUnits lists the values accepted by STW_SPEED_UNIT.
+var speedUnits = map[string]speedUnit{
+	"bps":  {OtelUnit: "bps", Divisor: 1},
+	"Mbps": {OtelUnit: "Mbit/s", Divisor: 1e6},
+	"MBps": {OtelUnit: "MBy/s", Divisor: 8e6},
+	"Gbps": {OtelUnit: "Gbit/s", Divisor: 1e9},
+}
+
+// loadSettings reads the STW_* environment variables into a Settings value.
+func loadSettings() (*Settings, error) {
+	s := &Settings{
+		Environment: strings.TrimSpace(os.Getenv("STW_ENVIRONMENT")),
+	}
+
+	var err error
+	if s.HistorySize, err = envInt("STW_HISTORY_SIZE", 0); err != nil {
+		return nil, err
+	}
+	if s.HistorySize < 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_HISTORY_SIZE %d: must not be negative", s.HistorySize)
+	}
+
+	if s.RecordEveryN, err = envInt("STW_RECORD_EVERY_N", 1); err != nil {
+		return nil, err
+	}
+	if s.RecordEveryN < 1 {
+		return nil, fmt.Errorf("invalid value for env var STW_RECORD_EVERY_N %d: must be at least 1", s.RecordEveryN)
+	}
+
+	if s.DashboardEnabled, err = envBool("STW_DASHBOARD_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if s.DashboardEnabled && s.HistorySize == 0 {
+		return nil, fmt.Errorf("STW_DASHBOARD_ENABLED requires STW_HISTORY_SIZE to be greater than 0")
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("STW_OTLP_DESTINATIONS")); raw != "" {
+		if s.OTLPDestinations, err = parseOTLPDestinations(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	s.OTLPCompression = strings.ToLower(strings.TrimSpace(os.Getenv("STW_OTLP_COMPRESSION")))
+	if !validOTLPCompression(s.OTLPCompression) {
+		return nil, fmt.Errorf("invalid value for env var STW_OTLP_COMPRESSION %s: must be gzip or none", s.OTLPCompression)
+	}
+
+	if s.OTLPMaxFailures, err = envInt("STW_OTLP_MAX_FAILURES", 0); err != nil {
+		return nil, err
+	}
+	if s.OTLPMaxFailures < 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_OTLP_MAX_FAILURES %d: must not be negative", s.OTLPMaxFailures)
+	}
+	if s.OTLPRecreateCooldown, err = envDuration("STW_OTLP_RECREATE_COOLDOWN", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if s.OTLPKeepaliveTime, err = envDuration("STW_OTLP_KEEPALIVE_TIME", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if s.OTLPKeepaliveTimeout, err = envDuration("STW_OTLP_KEEPALIVE_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if s.OTLPKeepaliveTimeout == 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_OTLP_KEEPALIVE_TIMEOUT: must be greater than 0")
+	}
+	if s.OTLPHeaders, err = envKeyValues("STW_OTLP_HEADERS"); err != nil {
+		return nil, err
+	}
+	if err := validateHeaders(s.OTLPHeaders); err != nil {
+		return nil, fmt.Errorf("invalid value for env var STW_OTLP_HEADERS: %w", err)
+	}
+
+	unitRaw := strings.TrimSpace(os.Getenv("STW_SPEED_UNIT"))
+	if unitRaw == "" {
+		unitRaw = "bps"
+	}
+	unit, ok := speedUnits[unitRaw]
+	if !ok {
+		return nil, fmt.Errorf("invalid value for env var STW_SPEED_UNIT %s: must be one of bps, Mbps, MBps, Gbps", unitRaw)
+	}
+	s.SpeedUnit = unit
+
+	if s.FailureHeuristics, err = parseFailureHeuristics(os.Getenv("STW_FAILURE_HEURISTIC")); err != nil {
+		return nil, err
+	}
+
+	if s.DrainDelay, err = envDuration("STW_DRAIN_DELAY", 0); err != nil {
+		return nil, err
+	}
+
+	staticAttrs, err := envKeyValues("STW_STATIC_METRIC_ATTRIBUTES")
+	if err != nil {
+		return nil, err
+	}
+	s.StaticMetricAttributes = toAttributes(staticAttrs)
+	renames, err := envKeyValues("STW_ATTRIBUTE_RENAMES")
+	if err != nil {
+		return nil, err
+	}
+	if s.AttributeRenames, err = parseAttributeRenames(renames, s.StaticMetricAttributes); err != nil {
+		return nil, err
+	}
+
+	s.RemoteWrite = remoteWriteConfig{
+		URL:         strings.TrimSpace(os.Getenv("STW_REMOTE_WRITE_URL")),
+		Username:    os.Getenv("STW_REMOTE_WRITE_USERNAME"),
+		Password:    os.Getenv("STW_REMOTE_WRITE_PASSWORD"),
+		BearerToken: os.Getenv("STW_REMOTE_WRITE_BEARER_TOKEN"),
+	}
+	if s.RemoteWrite.Interval, err = envDuration("STW_REMOTE_WRITE_INTERVAL", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if s.RemoteWrite.Interval == 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_REMOTE_WRITE_INTERVAL: must be greater than 0")
+	}
+
+	if s.NoExport, err = envBool("STW_NO_EXPORT", false); err != nil {
+		return nil, err
+	}
+
+	customHeaders, err := envKeyValues("STW_RESPONSE_HEADERS")
+	if err != nil {
+		return nil, err
+	}
+	if err := validateHeaders(customHeaders); err != nil {
+		return nil, fmt.Errorf("invalid value for env var STW_RESPONSE_HEADERS: %w", err)
+	}
+	s.ResponseHeaders = responseHeaders(customHeaders)
+
+	s.AllowedServices = parseAllowedServices(os.Getenv("STW_ALLOWED_SERVICES"))
+
+	aliases, err := envKeyValues("STW_SERVER_NAME_ALIASES")
+	if err != nil {
+		return nil, err
+	}
+	if aliases != nil {
+		s.ServerNameAliases = make(map[string]string, len(aliases))
+		for name, alias := range aliases {
+			s.ServerNameAliases[strings.ToLower(strings.Join(strings.Fields(name), " "))] = alias
+		}
+	}
+	if s.ServerNameNormalize, err = envBool("STW_SERVER_NAME_NORMALIZE", false); err != nil {
+		return nil, err
+	}
+
+	s.Statsd = statsdConfig{
+		Addr:   strings.TrimSpace(os.Getenv("STW_STATSD_ADDR")),
+		Prefix: "speedtest.",
+	}
+	if raw, ok := os.LookupEnv("STW_STATSD_PREFIX"); ok {
+		s.Statsd.Prefix = strings.TrimSpace(raw)
+	}
+	if s.Statsd.Tags, err = envBool("STW_STATSD_TAGS", true); err != nil {
+		return nil, err
+	}
+	if s.Statsd.FlushInterval, err = envDuration("STW_STATSD_FLUSH_INTERVAL", time.Second); err != nil {
+		return nil, err
+	}
+	if s.Statsd.FlushInterval == 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_STATSD_FLUSH_INTERVAL: must be greater than 0")
+	}
+
+	s.SpanName = strings.TrimSpace(os.Getenv("STW_SPAN_NAME"))
+	if s.SpanName == "" {
+		s.SpanName = "handleWebhookRequest"
+	}
+	spanAttrs, err := envKeyValues("STW_SPAN_ATTRIBUTES")
+	if err != nil {
+		return nil, err
+	}
+	s.SpanAttributes = toAttributes(spanAttrs)
+	if s.SpanHTTPMetadata, err = envBool("STW_SPAN_HTTP_METADATA", false); err != nil {
+		return nil, err
+	}
+
+	s.TrackerAPIURL = strings.TrimSpace(os.Getenv("STW_TRACKER_API_URL"))
+	s.TrackerAPIToken = strings.TrimSpace(os.Getenv("STW_TRACKER_API_TOKEN"))
+	if (s.TrackerAPIURL == "") != (s.TrackerAPIToken == "") {
+		return nil, fmt.Errorf("STW_TRACKER_API_URL and STW_TRACKER_API_TOKEN must be set together")
+	}
+
+	if s.ExportGate, err = envBool("STW_EXPORT_GATE", false); err != nil {
+		return nil, err
+	}
+	if s.ExportGateTimeout, err = envDuration("STW_EXPORT_GATE_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+
+	s.AdminToken = strings.TrimSpace(os.Getenv("STW_ADMIN_TOKEN"))
+	s.AdminUser = os.Getenv("STW_ADMIN_USER")
+	s.AdminPassword = os.Getenv("STW_ADMIN_PASSWORD")
+	if (s.AdminUser == "") != (s.AdminPassword == "") {
+		return nil, fmt.Errorf("STW_ADMIN_USER and STW_ADMIN_PASSWORD must be set together")
+	}
+	if s.AllowReset, err = envBool("STW_ALLOW_RESET", false); err != nil {
+		return nil, err
+	}
+	s.SinkStateFile = strings.TrimSpace(os.Getenv("STW_SINK_STATE_FILE"))
+
+	maxBody, err := envInt("STW_MAX_BODY_BYTES", 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	if maxBody < 1 {
+		return nil, fmt.Errorf("invalid value for env var STW_MAX_BODY_BYTES %d: must be at least 1", maxBody)
+	}
+	s.MaxBodyBytes = int64(maxBody)
+
+	streamBatch, err := envInt("STW_STREAM_BATCH_BYTES", 0)
+	if err != nil {
+		return nil, err
+	}
+	if streamBatch < 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_STREAM_BATCH_BYTES %d: must not be negative", streamBatch)
+	}
+	s.StreamBatchBytes = int64(streamBatch)
+	maxStream, err := envInt("STW_MAX_STREAM_BYTES", 1<<30)
+	if err != nil {
+		return nil, err
+	}
+	if maxStream < 1 {
+		return nil, fmt.Errorf("invalid value for env var STW_MAX_STREAM_BYTES %d: must be at least 1", maxStream)
+	}
+	s.MaxStreamBytes = int64(maxStream)
+	if s.StreamBatchBytes > 0 && s.StreamBatchBytes >= s.MaxStreamBytes {
+		return nil, fmt.Errorf("invalid value for env var STW_STREAM_BATCH_BYTES %d: must be less than STW_MAX_STREAM_BYTES (%d)", s.StreamBatchBytes, s.MaxStreamBytes)
+	}
+	if s.MaxBatchSize, err = envInt("STW_MAX_BATCH_SIZE", 0); err != nil {
+		return nil, err
+	}
+	if s.MaxBatchSize < 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_MAX_BATCH_SIZE %d: must not be negative", s.MaxBatchSize)
+	}
+	switch raw := strings.ToLower(strings.TrimSpace(os.Getenv("STW_MAX_BATCH_POLICY"))); raw {
+	case "", "reject":
+	case "truncate":
+		s.MaxBatchTruncate = true
+	default:
+		return nil, fmt.Errorf("invalid value for env var STW_MAX_BATCH_POLICY %s: must be reject or truncate", raw)
+	}
+
+	if s.StrictJSON, err = envBool("STW_STRICT_JSON", false); err != nil {
+		return nil, err
+	}
+
+	s.AdminAddr = strings.TrimSpace(os.Getenv("STW_ADMIN_ADDR"))
+
+	if s.MaxResultAge, err = envDuration("STW_MAX_RESULT_AGE", 0); err != nil {
+		return nil, err
+	}
+
+	if s.ServerLocationMetricAttributes, err = envBool("STW_SERVER_LOCATION_METRIC_ATTRIBUTES", false); err != nil {
+		return nil, err
+	}
+
+	fieldMap, err := envKeyValues("STW_FIELD_MAP")
+	if err != nil {
+		return nil, err
+	}
+	if s.FieldMap, err = parseFieldMap(fieldMap); err != nil {
+		return nil, err
+	}
+
+	if s.OtelRequired, err = envBool("STW_OTEL_REQUIRED", true); err != nil {
+		return nil, err
+	}
+	if s.OtelRetryInterval, err = envDuration("STW_OTEL_RETRY_INTERVAL", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if s.OtelRetryInterval == 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_OTEL_RETRY_INTERVAL: must be greater than 0")
+	}
+
+	s.ConnectionType = strings.TrimSpace(os.Getenv("STW_CONNECTION_TYPE"))
+
+	if s.CORSOrigins, err = parseCORSOrigins(os.Getenv("STW_CORS_ORIGINS")); err != nil {
+		return nil, err
+	}
+
+	if err := loadTLSSettings(s); err != nil {
+		return nil, err
+	}
+
+	if s.PerSiteMeters, err = envBool("STW_PER_SITE_METERS", false); err != nil {
+		return nil, err
+	}
+	if s.MaxSites, err = envInt("STW_MAX_SITES", 50); err != nil {
+		return nil, err
+	}
+	if s.MaxSites < 1 {
+		return nil, fmt.Errorf("invalid value for env var STW_MAX_SITES %d: must be at least 1", s.MaxSites)
+	}
+
+	if err := loadLoggingSettings(s); err != nil {
+		return nil, err
+	}
+
+	switch raw := strings.ToLower(strings.TrimSpace(os.Getenv("STW_MISSING_PACKET_LOSS"))); raw {
+	case "", "skip":
+	case "zero":
+		s.MissingPacketLossZero = true
+	default:
+		return nil, fmt.Errorf("invalid value for env var STW_MISSING_PACKET_LOSS %s: must be skip or zero", raw)
+	}
+
+	if s.ValuePrecision, err = envInt("STW_VALUE_PRECISION", -1); err != nil {
+		return nil, err
+	}
+	if s.ValuePrecision < -1 || s.ValuePrecision > 15 {
+		return nil, fmt.Errorf("invalid value for env var STW_VALUE_PRECISION %d: must be between 0 and 15, or -1 to disable", s.ValuePrecision)
+	}
+
+	s.GeoIPDB = strings.TrimSpace(os.Getenv("STW_GEOIP_DB"))
+
+	switch s.GoodStreakTier = strings.ToLower(strings.TrimSpace(os.Getenv("STW_GOOD_STREAK_TIER"))); s.GoodStreakTier {
+	case "":
+		s.GoodStreakTier = qualityGood
+	case qualityGood, qualityOK:
+	default:
+		return nil, fmt.Errorf("invalid value for env var STW_GOOD_STREAK_TIER %s: must be good or ok", s.GoodStreakTier)
+	}
+	switch s.GaugeWriteOrder = strings.ToLower(strings.TrimSpace(os.Getenv("STW_GAUGE_WRITE_ORDER"))); s.GaugeWriteOrder {
+	case "":
+		s.GaugeWriteOrder = gaugeOrderArrival
+	case gaugeOrderArrival, gaugeOrderTimestamp:
+	default:
+		return nil, fmt.Errorf("invalid value for env var STW_GAUGE_WRITE_ORDER %s: must be arrival or timestamp", s.GaugeWriteOrder)
+	}
+
+	if s.CardinalityReportInterval, err = envDuration("STW_CARDINALITY_REPORT_INTERVAL", 0); err != nil {
+		return nil, err
+	}
+
+	s.RulesFile = strings.TrimSpace(os.Getenv("STW_RULES_FILE"))
+	s.ConfigFile = strings.TrimSpace(os.Getenv("STW_CONFIG_FILE"))
+	s.ThresholdsFile = strings.TrimSpace(os.Getenv("STW_THRESHOLDS_FILE"))
+	if s.ThresholdsFile != "" {
+		if err := applyThresholdsFile(s.ThresholdsFile); err != nil {
+			return nil, err
+		}
+	}
+	if s.thresholdSettings, err = loadThresholds(); err != nil {
+		return nil, err
+	}
+	if s.AlertRetries, err = envInt("STW_ALERT_RETRIES", 3); err != nil {
+		return nil, err
+	}
+	if s.AlertRetries < 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_ALERT_RETRIES %d: must not be negative", s.AlertRetries)
+	}
+	if s.AlertCooldown, err = envDuration("STW_ALERT_COOLDOWN", 0); err != nil {
+		return nil, err
+	}
+	s.AlertDeadLetterFile = strings.TrimSpace(os.Getenv("STW_ALERT_DEAD_LETTER_FILE"))
+
+	if s.BaselineWindow, err = envInt("STW_BASELINE_WINDOW", 0); err != nil {
+		return nil, err
+	}
+	if s.BaselineWindow < 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_BASELINE_WINDOW %d: must not be negative", s.BaselineWindow)
+	}
+	if s.DownloadRangeWindow, err = envDuration("STW_DOWNLOAD_RANGE_WINDOW", 0); err != nil {
+		return nil, err
+	}
+
+	if s.Tenants, err = parseTenants(os.Getenv("STW_TENANTS")); err != nil {
+		return nil, err
+	}
+
+	if s.RejectTrailingData, err = envBool("STW_REJECT_TRAILING_DATA", false); err != nil {
+		return nil, err
+	}
+
+	if s.StaleAfter, err = envDuration("STW_STALE_AFTER", 0); err != nil {
+		return nil, err
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("STW_FORWARD_TARGETS")); raw != "" {
+		if s.ForwardTargets, err = parseForwardTargets(raw); err != nil {
+			return nil, err
+		}
+	}
+	s.ForwardSecret = os.Getenv("STW_FORWARD_SECRET")
+	if s.SinkTimeout, err = envDuration("STW_SINK_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if s.SinkTimeout == 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_SINK_TIMEOUT: must be greater than 0")
+	}
+
+	if s.SuppressIdenticalWindow, err = envDuration("STW_SUPPRESS_IDENTICAL_WINDOW", 0); err != nil {
+		return nil, err
+	}
+	if s.SuppressIdenticalTolerance, err = envFloat("STW_SUPPRESS_IDENTICAL_TOLERANCE", 0.1); err != nil {
+		return nil, err
+	}
+
+	extraFields, err := envKeyValues("STW_EXTRA_NUMERIC_FIELDS")
+	if err != nil {
+		return nil, err
+	}
+	if s.ExtraNumericFields, err = parseExtraNumericFields(extraFields); err != nil {
+		return nil, err
+	}
+
+	if s.MaxSpanEvents, err = envInt("STW_MAX_SPAN_EVENTS", 128); err != nil {
+		return nil, err
+	}
+	if s.MaxSpanEvents < 1 {
+		return nil, fmt.Errorf("invalid value for env var STW_MAX_SPAN_EVENTS %d: must be at least 1", s.MaxSpanEvents)
+	}
+
+	if s.FlushPerRequest, err = envBool("STW_FLUSH_PER_REQUEST", false); err != nil {
+		return nil, err
+	}
+	if s.SlowRequestThreshold, err = envDuration("STW_SLOW_REQUEST_THRESHOLD", 0); err != nil {
+		return nil, err
+	}
+
+	if s.WebhookHead, err = envBool("STW_WEBHOOK_HEAD", true); err != nil {
+		return nil, err
+	}
+
+	s.JSONL.Path = strings.TrimSpace(os.Getenv("STW_JSONL_PATH"))
+	jsonlMaxMB, err := envInt("STW_JSONL_MAX_SIZE_MB", 100)
+	if err != nil {
+		return nil, err
+	}
+	if jsonlMaxMB < 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_JSONL_MAX_SIZE_MB %d: must not be negative", jsonlMaxMB)
+	}
+	s.JSONL.MaxBytes = int64(jsonlMaxMB) << 20
+	if s.JSONL.MaxAge, err = envDuration("STW_JSONL_MAX_AGE", 0); err != nil {
+		return nil, err
+	}
+	if s.JSONL.MaxFiles, err = envInt("STW_JSONL_MAX_FILES", 5); err != nil {
+		return nil, err
+	}
+	if s.JSONL.MaxFiles < 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_JSONL_MAX_FILES %d: must not be negative", s.JSONL.MaxFiles)
+	}
+	if s.JSONL.Compress, err = envBool("STW_JSONL_COMPRESS", false); err != nil {
+		return nil, err
+	}
+
+	if s.PlanDownloadMbps, err = envFloat("STW_PLAN_DOWNLOAD_MBPS", 0); err != nil {
+		return nil, err
+	}
+	if s.PlanUploadMbps, err = envFloat("STW_PLAN_UPLOAD_MBPS", 0); err != nil {
+		return nil, err
+	}
+
+	if s.QualityScore, err = envBool("STW_QUALITY_SCORE", false); err != nil {
+		return nil, err
+	}
+	if s.QualityScoreWeights, err = parseQualityScoreFactors("STW_QUALITY_SCORE_WEIGHTS", defaultQualityScoreWeights); err != nil {
+		return nil, err
+	}
+	// Full marks default to the plan speeds, when configured.
+	targets := qualityScoreFactors{Download: 100, Upload: 20, Ping: 20, Jitter: 5, PacketLoss: 5}
+	if s.PlanDownloadMbps > 0 {
+		targets.Download = s.PlanDownloadMbps
+	}
+	if s.PlanUploadMbps > 0 {
+		targets.Upload = s.PlanUploadMbps
+	}
+	if s.QualityScoreTargets, err = parseQualityScoreFactors("STW_QUALITY_SCORE_TARGETS", targets); err != nil {
+		return nil, err
+	}
+	if t := s.QualityScoreTargets; t.Download == 0 || t.Upload == 0 || t.Ping == 0 || t.Jitter == 0 || t.PacketLoss == 0 {
+		return nil, fmt.Errorf("invalid value for env var STW_QUALITY_SCORE_TARGETS: targets must be greater than 0")
+	}
+
+	if s.RecordClient, err = envBool("STW_RECORD_CLIENT", false); err != nil {
+		return nil, err
+	}
+	if s.PrettyJSON, err = envBool("STW_PRETTY_JSON", false); err != nil {
+		return nil, err
+	}
+
+	if s.RuntimeMetrics, err = envBool("STW_RUNTIME_METRICS", true); err != nil {
+		return nil, err
+	}
+
+	s.WebhookSecret = os.Getenv("STW_WEBHOOK_SECRET")
+	s.SignatureHeader = strings.TrimSpace(os.Getenv("STW_SIGNATURE_HEADER"))
+	if s.SignatureHeader == "" {
+		s.SignatureHeader = "X-Signature"
+	}
+	if s.SignatureFormat, err = parseSignatureFormat(os.Getenv("STW_SIGNATURE_FORMAT")); err != nil {
+		return nil, err
+	}
+
+	s.AuthQueryParam = strings.TrimSpace(os.Getenv("STW_AUTH_QUERY_PARAM"))
+	s.AuthQueryToken = os.Getenv("STW_AUTH_QUERY_TOKEN")
+	if (s.AuthQueryParam == "") != (s.AuthQueryToken == "") {
+		return nil, fmt.Errorf("STW_AUTH_QUERY_PARAM and STW_AUTH_QUERY_TOKEN must be set together")
+	}
+
+	s.TransformExpr = strings.TrimSpace(os.Getenv("STW_TRANSFORM_EXPR"))
+	if s.TransformExpr != "" {
+		if s.Transform, err = compileTransform(s.TransformExpr); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// envInt returns the integer value of the env var key, or def when it is unset.
+func envInt(key string, def int) (int, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for env var %s %s", key, raw)
+	}
+	return v, nil
+}
+
+// envFloat returns the non-negative number in the env var key, or def when it is unset.
+func envFloat(key string, def float64) (float64, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid value for env var %s %s", key, raw)
+	}
+	return v, nil
+}
+
+// envBool returns the boolean value of the env var key, or def when it is unset.
+func envBool(key string, def bool) (bool, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for env var %s %s", key, raw)
+	}
+	return v, nil
+}
+
+// envDuration returns the duration value of the env var key, or def when it is unset.
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return def, nil
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid value for env var %s %s", key, raw)
+	}
+	return v, nil
+}
+
+// envKeyValues parses an env var holding a "key=value,key2=value2" list.
+func envKeyValues(key string) (map[string]string, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return nil, nil
+	}
+	out := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		k, v, ok := strings.Cut(entry, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid value for env var %s: malformed entry %q, expected key=value", key, entry)
+		}
+		if _, dup := out[k]; dup {
+			return nil, fmt.Errorf("invalid value for env var %s: duplicate key %q", key, k)
+		}
+		out[k] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
+
+// toAttributes converts a key/value map into string attributes sorted by key.
+func toAttributes(kv map[string]string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(kv))
+	for k, v := range kv {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+const redacted = "REDACTED"
+
+// redacted returns a copy of the settings with credentials masked, for display.
+// URLs are reduced to their scheme, host and path, as credentials can be
+// embedded in their user info or query.
+func (s Settings) redacted() Settings {
+	mask := func(v string) string {
+		if v == "" {
+			return ""
+		}
+		return redacted
+	}
+	maskURL := func(v string) string {
+		if v == "" {
+			return ""
+		}
+		if t := urlTarget(v); t != "" {
+			return t
+		}
+		return redacted
+	}
+	s.TrackerAPIURL = maskURL(s.TrackerAPIURL)
+	s.TrackerAPIToken = mask(s.TrackerAPIToken)
+	s.AdminToken = mask(s.AdminToken)
+	s.AdminPassword = mask(s.AdminPassword)
+	s.WebhookSecret = mask(s.WebhookSecret)
+	s.AuthQueryToken = mask(s.AuthQueryToken)
+	s.RemoteWrite.URL = maskURL(s.RemoteWrite.URL)
+	s.RemoteWrite.Password = mask(s.RemoteWrite.Password)
+	s.RemoteWrite.BearerToken = mask(s.RemoteWrite.BearerToken)
+
+	dests := make([]otlpDestination, len(s.OTLPDestinations))
+	for i, d := range s.OTLPDestinations {
+		d.Endpoint = maskURL(d.Endpoint)
+		d.APIKey = mask(d.APIKey)
+		headers := make(map[string]string, len(d.Headers))
+		for k, v := range d.Headers {
+			headers[k] = mask(v)
+		}
+		d.Headers = headers
+		dests[i] = d
+	}
+	s.OTLPDestinations = dests
+	if s.OTLPHeaders != nil {
+		headers := make(map[string]string, len(s.OTLPHeaders))
+		for k := range s.OTLPHeaders {
+			headers[k] = redacted
+		}
+		s.OTLPHeaders = headers
+	}
+
+	s.ForwardSecret = mask(s.ForwardSecret)
+	targets := make([]forwardTarget, len(s.ForwardTargets))
+	for i, t := range s.ForwardTargets {
+		t.URL = maskURL(t.URL)
+		t.Secret = mask(t.Secret)
+		headers := make(map[string]string, len(t.Headers))
+		for k, v := range t.Headers {
+			headers[k] = mask(v)
+		}
+		t.Headers = headers
+		targets[i] = t
+	}
+	s.ForwardTargets = targets
+	return s
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultServerPort is used when neither STW_SERVER_PORT nor server.port is set.
+const defaultServerPort = 8080
+
+// loadConfigFile reads the YAML config file. Unknown keys are rejected so a
+// misspelled key doesn't silently fall back to a default.
+func loadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// resolvePort picks the server port from STW_SERVER_PORT, then the config
+// file's server.port, then defaultServerPort, and reports which one it used.
+func resolvePort(cfg *Config) (int, string, error) {
+	if raw := os.Getenv("STW_SERVER_PORT"); raw != "" {
+		port, err := strconv.Atoi(raw)
+		if err != nil || !validPort(port) {
+			return 0, "", fmt.Errorf("invalid value for env var STW_SERVER_PORT %s, expected a port between 1 and 65535", raw)
+		}
+		return port, "STW_SERVER_PORT", nil
+	}
+	if cfg != nil && cfg.Server.Port != 0 {
+		if !validPort(cfg.Server.Port) {
+			return 0, "", fmt.Errorf("invalid server.port %d in config file %s, expected a port between 1 and 65535", cfg.Server.Port, settings.ConfigFile)
+		}
+		return cfg.Server.Port, "config file " + settings.ConfigFile, nil
+	}
+	return defaultServerPort, "default", nil
+}
+
+func validPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
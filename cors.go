@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// corsAllowedHeaders are the request headers browser clients may send.
+const corsAllowedHeaders = "Content-Type, Authorization, X-Request-ID"
+
+// parseCORSOrigins validates the STW_CORS_ORIGINS list. Each entry must be "*" or
+// a bare scheme://host[:port] origin.
+func parseCORSOrigins(raw string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var origins []string
+	for _, o := range strings.Split(raw, ",") {
+		o = strings.TrimSuffix(strings.TrimSpace(o), "/")
+		if o != "*" {
+			u, err := url.Parse(o)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+				u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+				return nil, fmt.Errorf("invalid value for env var STW_CORS_ORIGINS: %q is not an origin", o)
+			}
+		}
+		origins = append(origins, o)
+	}
+	return origins, nil
+}
+
+// corsOriginAllowed reports whether a request Origin matches the configured list.
+func corsOriginAllowed(origin string) bool {
+	return slices.Contains(settings.CORSOrigins, "*") || slices.Contains(settings.CORSOrigins, origin)
+}
+
+// withCORS adds CORS headers for allowed origins and answers preflight requests
+// for the given methods. Every response varies by Origin, so shared caches
+// don't serve one without CORS headers to an allowed origin. It returns next
+// unchanged when CORS is not configured.
+func withCORS(next http.Handler, methods ...string) http.Handler {
+	if len(settings.CORSOrigins) == 0 {
+		return next
+	}
+	allowMethods := strings.Join(append(methods, http.MethodOptions), ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsOriginAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if slices.Contains(settings.CORSOrigins, "*") {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", allowMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			h.Set("Allow", allowMethods)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", "X-Request-ID")
+		next.ServeHTTP(w, r)
+	})
+}
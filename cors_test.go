@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSAlwaysVariesByOrigin(t *testing.T) {
+	useSettings(t, map[string]string{"STW_CORS_ORIGINS": "https://dash.example.com"})
+	h := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), http.MethodGet)
+	for _, origin := range []string{"", "https://evil.example.com", "https://dash.example.com"} {
+		req := httptest.NewRequest(http.MethodGet, "/results", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("origin %q: Vary = %q, want Origin", origin, got)
+		}
+		allowed := rec.Header().Get("Access-Control-Allow-Origin")
+		if want := origin == "https://dash.example.com"; (allowed != "") != want {
+			t.Errorf("origin %q: Access-Control-Allow-Origin = %q", origin, allowed)
+		}
+	}
+}
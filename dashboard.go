@@ -0,0 +1,20 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFS embed.FS
+
+// dashboardHandler serves the embedded dashboard page, which renders data from /results.
+func dashboardHandler() http.Handler {
+	sub, err := fs.Sub(webFS, "web")
+	if err != nil {
+		// The embedded directory is fixed at build time, so this cannot fail at runtime.
+		panic(err)
+	}
+	return http.FileServerFS(sub)
+}
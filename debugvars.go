@@ -0,0 +1,13 @@
+package main
+
+import "expvar"
+
+// Internal counters published as JSON on /debug/vars of the internal listener,
+// for a quick look at the service state without a metrics backend.
+var (
+	receivedVar   = expvar.NewInt("results_received")
+	failedVar     = expvar.NewInt("results_failed")
+	suppressedVar = expvar.NewInt("results_suppressed")
+	// sinkErrorsVar counts failed sends per sink name.
+	sinkErrorsVar = expvar.NewMap("sink_errors")
+)
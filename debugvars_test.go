@@ -0,0 +1,22 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"testing"
+)
+
+func TestResultsReceivedCountsBatchResults(t *testing.T) {
+	useSettings(t, nil)
+	useInstruments(t, nil)
+	before := receivedVar.Value()
+	if rec := postWebhook(http.HandlerFunc(webhookHandler), "["+testPayload+","+testPayload+"]"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	if got := receivedVar.Value() - before; got != 2 {
+		t.Errorf("results_received grew by %d for a batch of 2, want 2", got)
+	}
+	if expvar.Get("webhooks_received") != nil {
+		t.Error("webhooks_received is still published")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errTrailingData reports bytes after a single payload object, or with
+// STW_REJECT_TRAILING_DATA after a batch too.
+var errTrailingData = errors.New("trailing data after JSON payload")
+
+// unknownFieldError reports a payload field rejected by STW_STRICT_JSON.
+type unknownFieldError struct {
+	Field string
+}
+
+func (e *unknownFieldError) Error() string {
+	return "unknown field " + e.Field + " in JSON payload"
+}
+
+// errTransform marks a result rejected by STW_TRANSFORM_EXPR.
+var errTransform = errors.New("transforming payload")
+
+// errEmptyBatch reports a batch body holding no results.
+var errEmptyBatch = errors.New("empty batch of results")
+
+// batchTooLargeError reports a batch of more than STW_MAX_BATCH_SIZE results
+// under the reject policy.
+type batchTooLargeError struct {
+	Max int
+}
+
+func (e *batchTooLargeError) Error() string {
+	return fmt.Sprintf("batch exceeds STW_MAX_BATCH_SIZE of %d results", e.Max)
+}
+
+// capBatch applies STW_MAX_BATCH_SIZE to a batch of n results, returning how
+// many to keep.
+func capBatch(n int) (int, error) {
+	if settings.MaxBatchSize == 0 || n <= settings.MaxBatchSize {
+		return n, nil
+	}
+	if !settings.MaxBatchTruncate {
+		return 0, &batchTooLargeError{settings.MaxBatchSize}
+	}
+	return settings.MaxBatchSize, nil
+}
+
+// decodePayloads parses a webhook body of the given Content-Type, converting it
+// to JSON first. A JSON array is a batch holding one result per element; any
+// other body is a single result. Anything after a single result is rejected, as
+// json.Unmarshal did; after a batch it is ignored unless STW_REJECT_TRAILING_DATA
+// is set. A batch beyond STW_MAX_BATCH_SIZE is
+// rejected, or truncated to its first results with dropped saying how many
+// were left out.
+func decodePayloads(contentType string, body []byte) (payloads []WebhookPayload, dropped int, err error) {
+	body, err = payloadJSON(contentType, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if settings.RejectTrailingData {
+		if err := checkTrailingData(body); err != nil {
+			return nil, 0, err
+		}
+	}
+	if !isJSONArray(body) {
+		payload, err := decodePayload(body)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []WebhookPayload{payload}, 0, nil
+	}
+
+	var elems []json.RawMessage
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&elems); err != nil {
+		return nil, 0, err
+	}
+	if len(elems) == 0 {
+		return nil, 0, errEmptyBatch
+	}
+	keep, err := capBatch(len(elems))
+	if err != nil {
+		return nil, 0, err
+	}
+	payloads = make([]WebhookPayload, keep)
+	for i, elem := range elems[:keep] {
+		if payloads[i], err = decodePayload(elem); err != nil {
+			return nil, 0, fmt.Errorf("result %d: %w", i, err)
+		}
+	}
+	return payloads, len(elems) - keep, nil
+}
+
+// isJSONArray reports whether body starts with a JSON array.
+func isJSONArray(body []byte) bool {
+	trimmed := bytes.TrimSpace(body)
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// decodePayload parses a single JSON result, remapping it with STW_FIELD_MAP
+// if set. In strict mode, fields that WebhookPayload doesn't model are rejected
+// with an *unknownFieldError, except for the STW_EXTRA_NUMERIC_FIELDS ones.
+// Anything but whitespace after the object is rejected with errTrailingData.
+func decodePayload(body []byte) (WebhookPayload, error) {
+	var payload WebhookPayload
+	// Extra fields are looked up in the body as received, before any remapping.
+	var extra map[string]float64
+	if len(settings.ExtraNumericFields) > 0 {
+		extra = extractExtraNumbers(body)
+	}
+	if settings.FieldMap != nil {
+		mapped, err := applyFieldMap(body, settings.FieldMap)
+		if err != nil {
+			return payload, err
+		}
+		body = mapped
+	}
+	if settings.StrictJSON && len(settings.ExtraNumericFields) > 0 {
+		body = withoutExtraFields(body)
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if settings.StrictJSON {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&payload); err != nil {
+		// encoding/json has no typed error for unknown fields, only this message.
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return payload, &unknownFieldError{Field: field}
+		}
+		return payload, err
+	}
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return payload, errTrailingData
+	}
+	payload.Extra = extra
+	return payload, nil
+}
+
+// checkTrailingData returns errTrailingData if body holds anything but
+// whitespace after its first JSON value. A malformed first value is left for
+// the payload decoder to report.
+func checkTrailingData(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(new(json.RawMessage)); err != nil {
+		return nil
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errTrailingData
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestDecodeRejectsTrailingData(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"STW_HISTORY_SIZE": "0"},
+		{"STW_HISTORY_SIZE": "0", "STW_STRICT_JSON": "true"},
+	} {
+		useSettings(t, env)
+		useInstruments(t, nil)
+		if _, err := decodePayload([]byte(testPayload + "xyz")); !errors.Is(err, errTrailingData) {
+			t.Errorf("%v: decodePayload error = %v, want errTrailingData", env, err)
+		}
+		if _, err := decodePayload([]byte(testPayload + " \n")); err != nil {
+			t.Errorf("%v: trailing whitespace: %v", env, err)
+		}
+		if rec := postWebhook(http.HandlerFunc(webhookHandler), testPayload+"xyz"); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: status = %d, want 400", env, rec.Code)
+		}
+	}
+}
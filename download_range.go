@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// downloadRangeMaxServers bounds how many servers get min/max gauges.
+	downloadRangeMaxServers = 1000
+	// downloadRangeMaxSamples bounds the samples kept per server; the oldest
+	// go first, so a busy server's window can be shorter than configured.
+	downloadRangeMaxSamples = 500
+)
+
+type downloadSample struct {
+	at    time.Time
+	value float64
+}
+
+type downloadWindow struct {
+	serverName string
+	samples    []downloadSample
+}
+
+// downloadRanges keeps, per server, the download speeds of the successful
+// results within STW_DOWNLOAD_RANGE_WINDOW.
+type downloadRanges struct {
+	mu      sync.Mutex
+	servers map[lastSeenKey]*downloadWindow
+}
+
+var serverDownloadRanges = &downloadRanges{servers: make(map[lastSeenKey]*downloadWindow)}
+
+// observe adds p's download speed to its server's window.
+func (d *downloadRanges) observe(tenant string, p WebhookPayload) {
+	key := lastSeenKey{tenant, p.ServerID}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.servers[key]
+	if !ok {
+		if len(d.servers) >= downloadRangeMaxServers {
+			return
+		}
+		w = &downloadWindow{}
+		d.servers[key] = w
+	}
+	w.serverName = p.ServerName
+	if len(w.samples) == downloadRangeMaxSamples {
+		w.samples = append(w.samples[:0], w.samples[1:]...)
+	}
+	w.samples = append(w.samples, downloadSample{at: time.Now(), value: float64(p.Download) / settings.SpeedUnit.Divisor})
+}
+
+// reset forgets every server's samples.
+func (d *downloadRanges) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.servers)
+}
+
+// registerDownloadRangeGauges registers the speedtest.download.min and
+// speedtest.download.max observable gauges. Each collection drops the samples
+// that left the window, and the servers left without any.
+func registerDownloadRangeGauges() error {
+	minGauge, err := meter.Float64ObservableGauge("speedtest.download.min",
+		metric.WithDescription("Lowest download speed of the server within STW_DOWNLOAD_RANGE_WINDOW"),
+		metric.WithUnit(settings.SpeedUnit.OtelUnit),
+	)
+	if err != nil {
+		return err
+	}
+	maxGauge, err := meter.Float64ObservableGauge("speedtest.download.max",
+		metric.WithDescription("Highest download speed of the server within STW_DOWNLOAD_RANGE_WINDOW"),
+		metric.WithUnit(settings.SpeedUnit.OtelUnit),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		cutoff := time.Now().Add(-settings.DownloadRangeWindow)
+		serverDownloadRanges.mu.Lock()
+		defer serverDownloadRanges.mu.Unlock()
+		for key, w := range serverDownloadRanges.servers {
+			i := 0
+			for i < len(w.samples) && w.samples[i].at.Before(cutoff) {
+				i++
+			}
+			w.samples = w.samples[i:]
+			if len(w.samples) == 0 {
+				delete(serverDownloadRanges.servers, key)
+				continue
+			}
+			lo, hi := w.samples[0].value, w.samples[0].value
+			for _, s := range w.samples[1:] {
+				lo, hi = min(lo, s.value), max(hi, s.value)
+			}
+			attrs := []attribute.KeyValue{
+				attribute.String("server.id", strconv.Itoa(key.serverID)),
+				attribute.String("server.name", w.serverName),
+			}
+			opt := metric.WithAttributes(renameAttributes(append(attrs, tenantAttributes(key.tenant)...))...)
+			o.ObserveFloat64(minGauge, roundValue(lo), opt)
+			o.ObserveFloat64(maxGauge, roundValue(hi), opt)
+		}
+		return nil
+	}, minGauge, maxGauge)
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// exportGateMaxHeld bounds how many results the export gate holds; further
+// results are recorded right away.
+const exportGateMaxHeld = 1000
+
+// exportGate holds webhook recording back until the first metric export has
+// succeeded, so results received right after a restart aren't lost. Held
+// results are recorded in the background once it opens, while the webhook
+// responds right away.
+type exportGate struct {
+	once     sync.Once
+	open     chan struct{}
+	mu       sync.Mutex
+	opened   bool
+	held     []func()
+	draining sync.WaitGroup
+	warned   bool
+}
+
+// metricsExportGate opens on the first successful export of any metric exporter.
+var metricsExportGate = &exportGate{open: make(chan struct{})}
+
+// hold queues record to run once the gate opens. It returns false, leaving the
+// caller to record right away, when the gate is open or already holds
+// exportGateMaxHeld results.
+func (g *exportGate) hold(record func()) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.opened {
+		return false
+	}
+	if len(g.held) >= exportGateMaxHeld {
+		if !g.warned {
+			log.Warnf("Export gate holds %d results; recording further results before the first metric export", exportGateMaxHeld)
+			g.warned = true
+		}
+		return false
+	}
+	g.held = append(g.held, record)
+	return true
+}
+
+// Open records the held results in the background; later calls are no-ops.
+func (g *exportGate) Open(reason string) {
+	g.once.Do(func() {
+		g.mu.Lock()
+		g.opened = true
+		held := g.held
+		g.held = nil
+		g.mu.Unlock()
+		log.Infof("Metrics export gate opened: %s; recording %d held results", reason, len(held))
+		close(g.open)
+		g.draining.Go(func() {
+			for _, record := range held {
+				record()
+			}
+		})
+	})
+}
+
+// Close opens the gate, if it isn't yet, and waits until the held results are
+// recorded or ctx is done, so they reach the final export at shutdown.
+func (g *exportGate) Close(ctx context.Context) {
+	g.Open("shutdown")
+	done := make(chan struct{})
+	go func() {
+		g.draining.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("Shutdown timed out recording results held by the export gate")
+	}
+}
+
+// OpenAfter opens the gate once timeout elapses, bounding how long handlers wait.
+func (g *exportGate) OpenAfter(timeout time.Duration) {
+	go func() {
+		select {
+		case <-g.open:
+		case <-time.After(timeout):
+			g.Open("timed out after " + timeout.String() + " without a successful export")
+		}
+	}()
+}
+
+// gatedExporter opens metricsExportGate when an export succeeds, and records
+// the outcome of every export in health.
+type gatedExporter struct {
+	metric.Exporter
+	health *integrationHealth
+}
+
+func (e gatedExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	err := e.Exporter.Export(ctx, rm)
+	e.health.observe(err)
+	if err == nil {
+		metricsExportGate.Open("first successful export")
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSink counts the results sent to it.
+type countingSink struct{ sent atomic.Int32 }
+
+func (s *countingSink) Name() string                             { return "counting" }
+func (s *countingSink) Kind() string                             { return "test" }
+func (s *countingSink) Target() string                           { return "memory" }
+func (s *countingSink) Close(context.Context) error              { return nil }
+func (s *countingSink) Send(context.Context, storedResult) error { s.sent.Add(1); return nil }
+
+func TestExportGateHoldsResultsWithoutBlocking(t *testing.T) {
+	useSettings(t, map[string]string{"STW_EXPORT_GATE": "true"})
+	useInstruments(t, nil)
+	prevGate, prevSinks := metricsExportGate, sinks
+	metricsExportGate = &exportGate{open: make(chan struct{})}
+	sinks = nil
+	t.Cleanup(func() { metricsExportGate, sinks = prevGate, prevSinks })
+	sink := &countingSink{}
+	registerSink(sink)
+
+	start := time.Now()
+	for range 3 {
+		if rec := postWebhook(http.HandlerFunc(webhookHandler), testPayload); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("webhooks took %s with the gate closed, want an immediate response", elapsed)
+	}
+	sinkSends.Wait()
+	if n := sink.sent.Load(); n != 0 {
+		t.Fatalf("%d results recorded before the gate opened", n)
+	}
+
+	metricsExportGate.Open("test")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	metricsExportGate.Close(ctx)
+	sinkSends.Wait()
+	if n := sink.sent.Load(); n != 3 {
+		t.Errorf("%d held results recorded, want 3", n)
+	}
+	if rec := postWebhook(http.HandlerFunc(webhookHandler), testPayload); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	sinkSends.Wait()
+	if n := sink.sent.Load(); n != 4 {
+		t.Errorf("%d results recorded after the gate opened, want 4", n)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// maxExtraNumericFields bounds STW_EXTRA_NUMERIC_FIELDS, since every entry is
+// a histogram of its own.
+const maxExtraNumericFields = 20
+
+// extraMetricName follows the OpenTelemetry instrument name syntax.
+var extraMetricName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_./-]{0,254}$`)
+
+// extraNumericField records the number at Path in the webhook body as the
+// histogram Metric.
+type extraNumericField struct {
+	Path   []string `json:"path"`
+	Metric string   `json:"metric"`
+}
+
+// extraHistograms are the STW_EXTRA_NUMERIC_FIELDS histograms by metric name.
+var extraHistograms map[string]metric.Float64Histogram
+
+// parseExtraNumericFields validates STW_EXTRA_NUMERIC_FIELDS entries mapping
+// dotted JSON paths in the incoming body to metric names, e.g.
+// lte.rsrp=speedtest.signal.rsrp.
+func parseExtraNumericFields(entries map[string]string) ([]extraNumericField, error) {
+	if len(entries) > maxExtraNumericFields {
+		return nil, fmt.Errorf("invalid value for env var STW_EXTRA_NUMERIC_FIELDS: %d fields exceed the limit of %d", len(entries), maxExtraNumericFields)
+	}
+	metrics := make(map[string]bool, len(entries))
+	fields := make([]extraNumericField, 0, len(entries))
+	for path, name := range entries {
+		segments := strings.Split(path, ".")
+		for _, seg := range segments {
+			if seg == "" {
+				return nil, fmt.Errorf("invalid value for env var STW_EXTRA_NUMERIC_FIELDS: malformed path %q", path)
+			}
+		}
+		if !extraMetricName.MatchString(name) {
+			return nil, fmt.Errorf("invalid value for env var STW_EXTRA_NUMERIC_FIELDS: invalid metric name %q for %s", name, path)
+		}
+		if metrics[name] {
+			return nil, fmt.Errorf("invalid value for env var STW_EXTRA_NUMERIC_FIELDS: metric %q is used twice", name)
+		}
+		metrics[name] = true
+		fields = append(fields, extraNumericField{Path: segments, Metric: name})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Metric < fields[j].Metric })
+	return fields, nil
+}
+
+// createExtraHistograms creates a histogram for every STW_EXTRA_NUMERIC_FIELDS
+// entry, collecting failures in errs.
+func createExtraHistograms(errs *[]error) {
+	extraHistograms = make(map[string]metric.Float64Histogram, len(settings.ExtraNumericFields))
+	for _, f := range settings.ExtraNumericFields {
+		h, err := meter.Float64Histogram(f.Metric, metric.WithDescription("Payload field "+strings.Join(f.Path, ".")))
+		if instrumentFailed(errs, f.Metric+" histogram", err) {
+			h = noop.Float64Histogram{}
+		}
+		extraHistograms[f.Metric] = h
+	}
+}
+
+// extractExtraNumbers returns the STW_EXTRA_NUMERIC_FIELDS values present in
+// body by metric name. Missing and non-numeric fields are skipped.
+func extractExtraNumbers(body []byte) map[string]float64 {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil
+	}
+	var values map[string]float64
+	for _, f := range settings.ExtraNumericFields {
+		v, ok := lookupPath(doc, f.Path)
+		if !ok {
+			continue
+		}
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		x, err := n.Float64()
+		if err != nil {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]float64)
+		}
+		values[f.Metric] = x
+	}
+	return values
+}
+
+// payloadFields are the JSON keys WebhookPayload models.
+var payloadFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeFor[WebhookPayload]()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// withoutExtraFields removes the top-level keys of the STW_EXTRA_NUMERIC_FIELDS
+// paths that WebhookPayload doesn't model from body, so STW_STRICT_JSON
+// doesn't reject them. Bodies that aren't JSON objects are returned as is for
+// the decoder to report.
+func withoutExtraFields(body []byte) []byte {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return body
+	}
+	removed := false
+	for _, f := range settings.ExtraNumericFields {
+		if key := f.Path[0]; !payloadFields[key] {
+			if _, ok := doc[key]; ok {
+				delete(doc, key)
+				removed = true
+			}
+		}
+	}
+	if !removed {
+		return body
+	}
+	stripped, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return stripped
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStrictJSONAllowsExtraNumericFields(t *testing.T) {
+	useSettings(t, map[string]string{
+		"STW_STRICT_JSON":          "true",
+		"STW_EXTRA_NUMERIC_FIELDS": "lte.rsrp=speedtest.signal.rsrp,temperature=speedtest.temperature",
+	})
+	body := []byte(`{"serverId": 7, "ping": 10, "download": 1, "upload": 1, "lte": {"rsrp": -95}, "temperature": 41.5}`)
+	payloads, _, err := decodePayloads("application/json", body)
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	p := payloads[0]
+	if p.ServerID != 7 || p.Extra["speedtest.signal.rsrp"] != -95 || p.Extra["speedtest.temperature"] != 41.5 {
+		t.Errorf("unexpected payload %+v", p)
+	}
+
+	if _, _, err := decodePayloads("application/json", []byte(`{"serverId": 7, "lte": {"rsrp": -95}, "bogus": 1}`)); err == nil {
+		t.Error("strict mode accepted a field outside STW_EXTRA_NUMERIC_FIELDS")
+	}
+}
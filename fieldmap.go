@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// payloadFieldNames returns the JSON names of the WebhookPayload fields.
+func payloadFieldNames() map[string]bool {
+	names := make(map[string]bool)
+	t := reflect.TypeOf(WebhookPayload{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parseFieldMap validates STW_FIELD_MAP entries mapping payload fields to dotted
+// JSON paths in the incoming body, e.g. download=data.down_bps.
+func parseFieldMap(entries map[string]string) (map[string][]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	known := payloadFieldNames()
+	fm := make(map[string][]string, len(entries))
+	for field, path := range entries {
+		if !known[field] {
+			return nil, fmt.Errorf("invalid value for env var STW_FIELD_MAP: unknown payload field %q", field)
+		}
+		segments := strings.Split(path, ".")
+		for _, seg := range segments {
+			if seg == "" {
+				return nil, fmt.Errorf("invalid value for env var STW_FIELD_MAP: malformed path %q for %s", path, field)
+			}
+		}
+		fm[field] = segments
+	}
+	return fm, nil
+}
+
+// applyFieldMap rewrites a custom JSON body into the canonical payload shape.
+// Mapped fields are looked up by path; top-level keys that root a mapped path are
+// dropped and every other key is kept as is, so unmapped fields use their default names.
+func applyFieldMap(body []byte, fm map[string][]string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]any, len(doc))
+	for k, v := range doc {
+		out[k] = v
+	}
+	for _, path := range fm {
+		delete(out, path[0])
+	}
+	for field, path := range fm {
+		if v, ok := lookupPath(doc, path); ok {
+			out[field] = v
+		} else {
+			delete(out, field)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// lookupPath walks objects by key and arrays by numeric index.
+func lookupPath(v any, path []string) (any, bool) {
+	for _, seg := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[seg]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
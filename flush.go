@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+// flushTimeout bounds the per-request metrics flush of STW_FLUSH_PER_REQUEST.
+const flushTimeout = 5 * time.Second
+
+// flushMetrics force-flushes the meter provider so the metrics just recorded
+// are exported without waiting for the export interval. It is a no-op while
+// telemetry is down and the no-op provider is installed.
+func flushMetrics(ctx context.Context) {
+	mp, ok := otel.GetMeterProvider().(interface{ ForceFlush(context.Context) error })
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, flushTimeout)
+	defer cancel()
+	if err := mp.ForceFlush(ctx); err != nil {
+		logFrom(ctx).Warnf("Could not flush metrics: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// forwardTarget is a downstream URL every result is re-posted to as JSON.
+type forwardTarget struct {
+	// Name identifies the sink; it defaults to forward-1, forward-2, ...
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	// Secret signs the body with HMAC-SHA256; empty falls back to STW_FORWARD_SECRET.
+	Secret string `json:"secret"`
+	// SignatureHeader carries the signature, X-Signature by default.
+	SignatureHeader string `json:"signatureHeader"`
+	// SignatureFormat is hex, base64 or github, as for STW_SIGNATURE_FORMAT.
+	SignatureFormat string `json:"signatureFormat"`
+}
+
+// parseForwardTargets decodes the JSON list held in STW_FORWARD_TARGETS and
+// fills in the defaults.
+func parseForwardTargets(raw string) ([]forwardTarget, error) {
+	var targets []forwardTarget
+	if err := json.Unmarshal([]byte(raw), &targets); err != nil {
+		return nil, fmt.Errorf("invalid value for env var STW_FORWARD_TARGETS: %w", err)
+	}
+	names := make(map[string]bool, len(targets))
+	for i := range targets {
+		t := &targets[i]
+		if u, err := url.Parse(t.URL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid value for env var STW_FORWARD_TARGETS: target %d has invalid url %q", i, t.URL)
+		}
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("forward-%d", i+1)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("invalid value for env var STW_FORWARD_TARGETS: duplicate target name %q", t.Name)
+		}
+		names[t.Name] = true
+		if t.SignatureHeader == "" {
+			t.SignatureHeader = "X-Signature"
+		}
+		format, ok := normalizeSignatureFormat(t.SignatureFormat)
+		if !ok {
+			return nil, fmt.Errorf("invalid value for env var STW_FORWARD_TARGETS: target %q has invalid signatureFormat %q, expected hex, base64 or github", t.Name, t.SignatureFormat)
+		}
+		t.SignatureFormat = format
+	}
+	return targets, nil
+}
+
+// forwardBody is the JSON posted to forward targets: the payload, with the
+// effective connectionType, and the STW_ENVIRONMENT it was received in.
+type forwardBody struct {
+	WebhookPayload
+	Environment string `json:"environment,omitempty"`
+}
+
+// forwardSink posts each result's payload to a downstream webhook, signing the
+// body when the target has a secret.
+type forwardSink struct {
+	target forwardTarget
+	secret string
+	client *http.Client
+}
+
+func newForwardSink(t forwardTarget, defaultSecret string) *forwardSink {
+	secret := t.Secret
+	if secret == "" {
+		secret = defaultSecret
+	}
+	return &forwardSink{
+		target: t,
+		secret: secret,
+		// Deliveries are bounded by STW_SINK_TIMEOUT through their context.
+		client: &http.Client{},
+	}
+}
+
+func (s *forwardSink) Name() string   { return s.target.Name }
+func (s *forwardSink) Kind() string   { return "forward" }
+func (s *forwardSink) Target() string { return urlTarget(s.target.URL) }
+
+// Send posts the result payload and fails on a non-2xx response. Errors name
+// the target as Target does, since its URL may carry credentials.
+func (s *forwardSink) Send(ctx context.Context, res storedResult) error {
+	fb := forwardBody{WebhookPayload: res.Payload, Environment: res.Environment}
+	if res.ConnectionType != "" {
+		fb.ConnectionType = res.ConnectionType
+	}
+	body, err := json.Marshal(fb)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.target.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "speedtest-tracker-webhook")
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	for k, v := range s.target.Headers {
+		req.Header.Set(k, v)
+	}
+	// Downstream receivers continue the trace under the sink.send span.
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if s.secret != "" {
+		req.Header.Set(s.target.SignatureHeader, encodeSignature(s.target.SignatureFormat, []byte(s.secret), body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = s.Target()
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded %s: %s", s.Target(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *forwardSink) Close(ctx context.Context) error { return nil }
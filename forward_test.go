@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwardSinkErrorsHideURLCredentials(t *testing.T) {
+	useSettings(t, nil)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	for name, base := range map[string]string{"status": receiver.URL, "transport": closed.URL} {
+		t.Run(name, func(t *testing.T) {
+			raw := strings.Replace(base, "http://", "http://user:url-pass@", 1) + "/in?token=url-token"
+			sink := newForwardSink(forwardTarget{Name: "fwd", URL: raw}, "")
+			err := sink.Send(context.Background(), storedResult{})
+			if err == nil {
+				t.Fatal("Send succeeded")
+			}
+			for _, secret := range []string{"url-pass", "url-token"} {
+				if strings.Contains(err.Error(), secret) {
+					t.Errorf("error %q contains %q", err, secret)
+				}
+			}
+			if !strings.Contains(err.Error(), sink.Target()) {
+				t.Errorf("error %q does not name %s", err, sink.Target())
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// gaugeOrderArrival records last-value gauges in the order webhooks are
+	// processed.
+	gaugeOrderArrival = "arrival"
+	// gaugeOrderTimestamp drops last-value gauge writes of results older than
+	// the newest one already recorded for their server.
+	gaugeOrderTimestamp = "timestamp"
+)
+
+// gaugeOrderMaxServers bounds how many servers the newest result time is kept
+// for; results of further servers are recorded in arrival order.
+const gaugeOrderMaxServers = 1000
+
+// gaugeOrder serializes the last-value gauge writes (speedtest.bufferbloat.grade,
+// speedtest.plan_ratio, speedtest.quality_score) of concurrent webhooks under
+// STW_GAUGE_WRITE_ORDER=timestamp, so a late, older result of a server can't
+// overwrite the values of a newer one.
+type gaugeOrder struct {
+	mu      sync.Mutex
+	servers map[lastSeenKey]time.Time
+}
+
+var serverGaugeOrder = &gaugeOrder{servers: make(map[lastSeenKey]time.Time)}
+
+// apply runs the gauge writes of p. Under the timestamp order they run while
+// holding the lock, and only when p is at least as new as the newest result
+// recorded for its server; p counts as received now when it has no timestamp.
+func (g *gaugeOrder) apply(logger *log.Entry, tenant string, p WebhookPayload, received time.Time, writes []func()) {
+	if len(writes) == 0 {
+		return
+	}
+	if settings.GaugeWriteOrder != gaugeOrderTimestamp {
+		for _, write := range writes {
+			write()
+		}
+		return
+	}
+	at := received
+	if p.Timestamp != nil && !p.Timestamp.IsZero() {
+		at = p.Timestamp.Time
+	}
+	key := lastSeenKey{tenant, p.ServerID}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	newest, ok := g.servers[key]
+	if ok && at.Before(newest) {
+		logger.Debugf("Not recording gauges of result %d for server ID %d: older than the last recorded result", p.ResultID, p.ServerID)
+		return
+	}
+	if ok || len(g.servers) < gaugeOrderMaxServers {
+		g.servers[key] = at
+	}
+	for _, write := range writes {
+		write()
+	}
+}
+
+// reset forgets the newest result time of every server.
+func (g *gaugeOrder) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	clear(g.servers)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// geoDB is the MaxMind database opened from STW_GEOIP_DB, or nil.
+var geoDB *geoip2.Reader
+
+// openGeoIP opens the GeoIP database. A missing or unreadable database only
+// disables the lookup, so it never prevents the service from starting.
+func openGeoIP(path string) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		log.Warnf("GeoIP lookups disabled: could not open %s: %v", path, err)
+		return
+	}
+	geoDB = db
+	log.Infof("GeoIP lookups enabled using %s (%s)", path, db.Metadata().DatabaseType)
+}
+
+// geoAttributes looks up the payload's public IP and returns its country and,
+// with a City database, city. It returns nil when there is nothing to add.
+func geoAttributes(logger *log.Entry, p WebhookPayload) []attribute.KeyValue {
+	if geoDB == nil || p.PublicIP == "" {
+		return nil
+	}
+	ip := net.ParseIP(p.PublicIP)
+	if ip == nil {
+		logger.Warnf("Ignoring invalid publicIp %q for GeoIP lookup", p.PublicIP)
+		return nil
+	}
+
+	var country, city string
+	rec, err := geoDB.City(ip)
+	var wrongDB geoip2.InvalidMethodError
+	switch {
+	case err == nil:
+		country, city = rec.Country.IsoCode, rec.City.Names["en"]
+	case errors.As(err, &wrongDB):
+		// A Country database has no city data.
+		c, cerr := geoDB.Country(ip)
+		if cerr != nil {
+			logger.Warnf("GeoIP lookup of %s failed: %v", p.PublicIP, cerr)
+			return nil
+		}
+		country = c.Country.IsoCode
+	default:
+		logger.Warnf("GeoIP lookup of %s failed: %v", p.PublicIP, err)
+		return nil
+	}
+
+	var attrs []attribute.KeyValue
+	if country != "" {
+		attrs = append(attrs, attribute.String("geo.country.iso_code", country))
+	}
+	if city != "" {
+		attrs = append(attrs, attribute.String("geo.locality.name", city))
+	}
+	return attrs
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// goodStreakMaxServers bounds how many servers speedtest.good_streak reports.
+const goodStreakMaxServers = 1000
+
+type goodStreak struct {
+	serverName string
+	count      int64
+}
+
+// goodStreaks counts, per server, the consecutive results at or above the
+// STW_GOOD_STREAK_TIER quality tier. They live in memory only, so a restart
+// starts every streak over.
+type goodStreaks struct {
+	mu      sync.Mutex
+	servers map[lastSeenKey]*goodStreak
+}
+
+var serverGoodStreaks = &goodStreaks{servers: make(map[lastSeenKey]*goodStreak)}
+
+// observe extends p's server streak when p is good enough and resets it
+// otherwise; failed tests always reset it.
+func (g *goodStreaks) observe(tenant string, p WebhookPayload, outcome string) {
+	good := false
+	if outcome == outcomeSuccess {
+		tier, _ := qualityTier(p)
+		good = tier == qualityGood || (tier == qualityOK && settings.GoodStreakTier == qualityOK)
+	}
+	key := lastSeenKey{tenant, p.ServerID}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, ok := g.servers[key]
+	if !ok {
+		if len(g.servers) >= goodStreakMaxServers {
+			return
+		}
+		s = &goodStreak{}
+		g.servers[key] = s
+	}
+	s.serverName = p.ServerName
+	if good {
+		s.count++
+	} else {
+		s.count = 0
+	}
+}
+
+// reset forgets every streak.
+func (g *goodStreaks) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	clear(g.servers)
+}
+
+// registerGoodStreakGauge registers the speedtest.good_streak observable gauge.
+func registerGoodStreakGauge() error {
+	_, err := meter.Int64ObservableGauge("speedtest.good_streak",
+		metric.WithDescription("Consecutive results of the server at or above the STW_GOOD_STREAK_TIER quality tier"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			serverGoodStreaks.mu.Lock()
+			defer serverGoodStreaks.mu.Unlock()
+			for key, s := range serverGoodStreaks.servers {
+				attrs := []attribute.KeyValue{
+					attribute.String("server.id", strconv.Itoa(key.serverID)),
+					attribute.String("server.name", s.serverName),
+				}
+				attrs = append(attrs, tenantAttributes(key.tenant)...)
+				o.Observe(s.count, metric.WithAttributes(renameAttributes(attrs)...))
+			}
+			return nil
+		}),
+	)
+	return err
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// integrationHealth records the outcomes of deliveries to one sink or OTLP
+// exporter.
+type integrationHealth struct {
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	failures    int64
+}
+
+// observe records the outcome of a delivery.
+func (h *integrationHealth) observe(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.lastSuccess = time.Now()
+		return
+	}
+	h.lastFailure = time.Now()
+	h.lastError = redactURLs(err.Error())
+	h.failures++
+}
+
+// errorURL matches the URLs that delivery errors quote, such as the one in a
+// *url.Error.
+var errorURL = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"']+`)
+
+// redactURLs reduces every URL in msg to its scheme, host and path, as
+// urlTarget does, since /health/detail may be served publicly and URLs can
+// carry credentials.
+func redactURLs(msg string) string {
+	return errorURL.ReplaceAllStringFunc(msg, func(raw string) string {
+		if t := urlTarget(raw); t != "" {
+			return t
+		}
+		return "REDACTED"
+	})
+}
+
+// healthState is the state of one integration in GET /health/detail.
+type healthState struct {
+	// Status is "ok", "degraded" when the last delivery failed, or "unknown"
+	// before the first delivery.
+	Status        string     `json:"status"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	LastFailureAt *time.Time `json:"last_failure_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	Errors        int64      `json:"errors"`
+}
+
+func (h *integrationHealth) state() healthState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := healthState{Status: "unknown", LastError: h.lastError, Errors: h.failures}
+	if !h.lastSuccess.IsZero() {
+		t := h.lastSuccess.UTC()
+		s.LastSuccessAt = &t
+		s.Status = "ok"
+	}
+	if !h.lastFailure.IsZero() {
+		t := h.lastFailure.UTC()
+		s.LastFailureAt = &t
+		if h.lastFailure.After(h.lastSuccess) {
+			s.Status = "degraded"
+		}
+	}
+	return s
+}
+
+// otlpHealth holds the metric export health per OTLP destination label. It
+// outlives SDK setups, so a retried setup keeps the history.
+var otlpHealth = struct {
+	mu    sync.Mutex
+	dests map[string]*integrationHealth
+}{dests: make(map[string]*integrationHealth)}
+
+// exporterHealth returns the health record of the destination labeled label.
+func exporterHealth(label string) *integrationHealth {
+	otlpHealth.mu.Lock()
+	defer otlpHealth.mu.Unlock()
+	h, ok := otlpHealth.dests[label]
+	if !ok {
+		h = &integrationHealth{}
+		otlpHealth.dests[label] = h
+	}
+	return h
+}
+
+type sinkHealth struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Target  string `json:"target"`
+	Enabled bool   `json:"enabled"`
+	healthState
+}
+
+type exporterHealthState struct {
+	Endpoint string `json:"endpoint"`
+	healthState
+}
+
+// healthDetailResponse is the body of GET /health/detail.
+type healthDetailResponse struct {
+	// Status is "degraded" when any enabled sink or OTLP exporter is.
+	Status string                `json:"status"`
+	Sinks  []sinkHealth          `json:"sinks"`
+	OTLP   []exporterHealthState `json:"otlp"`
+}
+
+// healthDetailHandler reports the health of every sink and OTLP metrics
+// exporter. It always answers 200: a degraded integration loses data but
+// doesn't make the service itself unhealthy, which /readyz covers.
+func healthDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	resp := healthDetailResponse{Status: "ok", Sinks: []sinkHealth{}, OTLP: []exporterHealthState{}}
+	for _, s := range sinks {
+		h := sinkHealth{
+			Name:        s.Name(),
+			Type:        s.Kind(),
+			Target:      s.Target(),
+			Enabled:     s.enabled.Load(),
+			healthState: s.health.state(),
+		}
+		// Sinks that queue results also count background write failures here.
+		if v, ok := sinkErrorsVar.Get(s.Name()).(*expvar.Int); ok {
+			h.Errors = v.Value()
+		}
+		if !h.Enabled {
+			h.Status = "disabled"
+		} else if h.Status == "degraded" {
+			resp.Status = "degraded"
+		}
+		resp.Sinks = append(resp.Sinks, h)
+	}
+	otlpHealth.mu.Lock()
+	for label, h := range otlpHealth.dests {
+		e := exporterHealthState{Endpoint: label, healthState: h.state()}
+		if e.Status == "degraded" {
+			resp.Status = "degraded"
+		}
+		resp.OTLP = append(resp.OTLP, e)
+	}
+	otlpHealth.mu.Unlock()
+	slices.SortFunc(resp.OTLP, func(a, b exporterHealthState) int { return strings.Compare(a.Endpoint, b.Endpoint) })
+	writeJSON(w, http.StatusOK, resp)
+}
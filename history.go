@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// storedResult is a received payload together with the time it arrived.
+type storedResult struct {
+	ReceivedAt time.Time `json:"received_at"`
+	Outcome    string    `json:"outcome"`
+	Tenant     string    `json:"tenant,omitempty"`
+	// Environment is STW_ENVIRONMENT and ConnectionType the payload
+	// connectionType or STW_CONNECTION_TYPE, when set.
+	Environment    string         `json:"environment,omitempty"`
+	ConnectionType string         `json:"connection_type,omitempty"`
+	Payload        WebhookPayload `json:"payload"`
+}
+
+// resultHistory is a fixed-size ring buffer holding the most recent results.
+type resultHistory struct {
+	mu   sync.RWMutex
+	buf  []storedResult
+	next int
+	full bool
+}
+
+func newResultHistory(size int) *resultHistory {
+	return &resultHistory{buf: make([]storedResult, size)}
+}
+
+// Add stores a result, overwriting the oldest one once the buffer is full.
+func (h *resultHistory) Add(r storedResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.buf[h.next] = r
+	h.next = (h.next + 1) % len(h.buf)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Snapshot returns a copy of the buffered results, oldest first.
+func (h *resultHistory) Snapshot() []storedResult {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if !h.full {
+		return append(make([]storedResult, 0, h.next), h.buf[:h.next]...)
+	}
+	out := make([]storedResult, 0, len(h.buf))
+	out = append(out, h.buf[h.next:]...)
+	return append(out, h.buf[:h.next]...)
+}
+
+// Reset empties the buffer.
+func (h *resultHistory) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	clear(h.buf)
+	h.next, h.full = 0, false
+}
+
+// resultsHandler returns the buffered results as JSON, oldest first.
+func resultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, history.Snapshot())
+}
+
+var csvHeader = []string{"timestamp", "server", "isp", "ping", "download", "upload", "packet_loss"}
+
+// resultsCSVHandler streams the buffered results as CSV, one row at a time.
+func resultsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="results.csv"`)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		log.Errorf("Could not write CSV header: %v", err)
+		return
+	}
+	for _, res := range history.Snapshot() {
+		p := res.Payload
+		packetLoss := ""
+		if p.PacketLoss != nil {
+			packetLoss = strconv.FormatFloat(float64(*p.PacketLoss), 'f', -1, 64)
+		}
+		row := []string{
+			res.ReceivedAt.UTC().Format(time.RFC3339),
+			p.ServerName,
+			p.ISP,
+			strconv.FormatFloat(float64(p.Ping), 'f', -1, 64),
+			strconv.FormatFloat(float64(p.Download), 'f', -1, 64),
+			strconv.FormatFloat(float64(p.Upload), 'f', -1, 64),
+			packetLoss,
+		}
+		if err := cw.Write(row); err != nil {
+			log.Errorf("Could not write CSV row: %v", err)
+			return
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Errorf("Could not flush CSV: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// identicalMaxServers bounds how many servers' last recorded result is kept.
+const identicalMaxServers = 1000
+
+// recordedResult is the last result recorded to metrics for a server.
+type recordedResult struct {
+	at                     time.Time
+	ping, download, upload float64
+}
+
+// identicalSuppressor drops results whose ping, download and upload all match
+// the last recorded result of the same server and tenant within
+// STW_SUPPRESS_IDENTICAL_WINDOW. Unlike result ID checks it also catches
+// clients re-posting a cached result under a new ID.
+type identicalSuppressor struct {
+	mu   sync.Mutex
+	last map[identicalKey]recordedResult
+}
+
+type identicalKey struct {
+	tenant   string
+	serverID int
+}
+
+var identicalResults = &identicalSuppressor{last: make(map[identicalKey]recordedResult)}
+
+// suppress reports whether p repeats the server's last recorded result. The
+// window starts at the recorded result, so repeats don't extend it; any other
+// result replaces it.
+func (s *identicalSuppressor) suppress(tenant string, p WebhookPayload) bool {
+	now := time.Now()
+	cur := recordedResult{at: now, ping: float64(p.Ping), download: float64(p.Download), upload: float64(p.Upload)}
+	key := identicalKey{tenant, p.ServerID}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.last[key]
+	if ok && now.Sub(prev.at) < settings.SuppressIdenticalWindow &&
+		withinTolerance(prev.ping, cur.ping) &&
+		withinTolerance(prev.download, cur.download) &&
+		withinTolerance(prev.upload, cur.upload) {
+		return true
+	}
+	if !ok && len(s.last) >= identicalMaxServers {
+		return false
+	}
+	s.last[key] = cur
+	return false
+}
+
+// reset forgets the last recorded result of every server.
+func (s *identicalSuppressor) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.last)
+}
+
+// withinTolerance reports whether a and b differ by at most
+// STW_SUPPRESS_IDENTICAL_TOLERANCE percent of the larger one.
+func withinTolerance(a, b float64) bool {
+	return math.Abs(a-b) <= math.Max(math.Abs(a), math.Abs(b))*settings.SuppressIdenticalTolerance/100
+}
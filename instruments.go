@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// createInstruments creates the global metric instruments. An instrument that
+// can't be created is replaced by a no-op one; the failures are returned when
+// STW_OTEL_REQUIRED is set and only logged otherwise, so a misconfigured SDK
+// disables the affected metrics without stopping the server.
+func createInstruments() error {
+	var errs []error
+	var err error
+	pingHistogram, err = meter.Float64Histogram("speedtest.ping", metric.WithDescription("Ping latency"), metric.WithUnit("ms"))
+	if instrumentFailed(&errs, "ping histogram", err) {
+		pingHistogram = noop.Float64Histogram{}
+	}
+	downloadHistogram, err = meter.Float64Histogram("speedtest.download", metric.WithDescription("Download speed"), metric.WithUnit(settings.SpeedUnit.OtelUnit))
+	if instrumentFailed(&errs, "download histogram", err) {
+		downloadHistogram = noop.Float64Histogram{}
+	}
+	uploadHistogram, err = meter.Float64Histogram("speedtest.upload", metric.WithDescription("Upload speed"), metric.WithUnit(settings.SpeedUnit.OtelUnit))
+	if instrumentFailed(&errs, "upload histogram", err) {
+		uploadHistogram = noop.Float64Histogram{}
+	}
+	durationHistogram, err = meter.Float64Histogram("speedtest.test_duration", metric.WithDescription("Time the speed test took to run"), metric.WithUnit("s"))
+	if instrumentFailed(&errs, "test duration histogram", err) {
+		durationHistogram = noop.Float64Histogram{}
+	}
+	jitterRatioHistogram, err = meter.Float64Histogram("speedtest.jitter_ratio", metric.WithDescription("Ping jitter divided by ping"), metric.WithUnit("1"))
+	if instrumentFailed(&errs, "jitter ratio histogram", err) {
+		jitterRatioHistogram = noop.Float64Histogram{}
+	}
+	ingestDelayHistogram, err = meter.Float64Histogram("speedtest.ingest_delay", metric.WithDescription("Time from the test's timestamp until its webhook arrived"), metric.WithUnit("s"))
+	if instrumentFailed(&errs, "ingest delay histogram", err) {
+		ingestDelayHistogram = noop.Float64Histogram{}
+	}
+	ingestBytesCounter, err = meter.Int64Counter("speedtest.ingest_bytes", metric.WithDescription("Webhook body bytes received, after decompression"), metric.WithUnit("By"))
+	if instrumentFailed(&errs, "ingest bytes counter", err) {
+		ingestBytesCounter = noop.Int64Counter{}
+	}
+	createExtraHistograms(&errs)
+	skippedCounter, err = meter.Int64Counter("speedtest.recordings.skipped", metric.WithDescription("Results not recorded to metrics due to STW_RECORD_EVERY_N"))
+	if instrumentFailed(&errs, "skipped recordings counter", err) {
+		skippedCounter = noop.Int64Counter{}
+	}
+	suppressedCounter, err = meter.Int64Counter("speedtest.recordings.suppressed", metric.WithDescription("Results not recorded to metrics because they repeat the last recorded values within STW_SUPPRESS_IDENTICAL_WINDOW"))
+	if instrumentFailed(&errs, "suppressed recordings counter", err) {
+		suppressedCounter = noop.Int64Counter{}
+	}
+	resultsCounter, err = meter.Int64Counter("speedtest.results", metric.WithDescription("Received results by outcome"))
+	if instrumentFailed(&errs, "results counter", err) {
+		resultsCounter = noop.Int64Counter{}
+	}
+	bufferbloatGauge, err = meter.Int64Gauge("speedtest.bufferbloat.grade", metric.WithDescription("Bufferbloat grade from 4 (A) to 0 (F)"))
+	if instrumentFailed(&errs, "bufferbloat gauge", err) {
+		bufferbloatGauge = noop.Int64Gauge{}
+	}
+	qualityCounter, err = meter.Int64Counter("speedtest.quality", metric.WithDescription("Successful results by quality tier"))
+	if instrumentFailed(&errs, "quality counter", err) {
+		qualityCounter = noop.Int64Counter{}
+	}
+	planRatioGauge, err = meter.Float64Gauge("speedtest.plan_ratio", metric.WithDescription("Achieved speed as a fraction of the STW_PLAN_*_MBPS plan speed"), metric.WithUnit("1"))
+	if instrumentFailed(&errs, "plan ratio gauge", err) {
+		planRatioGauge = noop.Float64Gauge{}
+	}
+	qualityScoreGauge, err = meter.Float64Gauge("speedtest.quality_score", metric.WithDescription("Composite result quality from 0 to 100"))
+	if instrumentFailed(&errs, "quality score gauge", err) {
+		qualityScoreGauge = noop.Float64Gauge{}
+	}
+	alertsCounter, err = meter.Int64Counter("speedtest.alerts", metric.WithDescription("Alerts fired by STW_RULES_FILE rules"))
+	if instrumentFailed(&errs, "alerts counter", err) {
+		alertsCounter = noop.Int64Counter{}
+	}
+	alertsSuppressedCounter, err = meter.Int64Counter("speedtest.alerts.suppressed", metric.WithDescription("STW_RULES_FILE breaches not notified within STW_ALERT_COOLDOWN"))
+	if instrumentFailed(&errs, "suppressed alerts counter", err) {
+		alertsSuppressedCounter = noop.Int64Counter{}
+	}
+	deviationGauge, err = meter.Float64Gauge("speedtest.deviation", metric.WithDescription("Deviation of a result from its server's rolling median"), metric.WithUnit("%"))
+	if instrumentFailed(&errs, "deviation gauge", err) {
+		deviationGauge = noop.Float64Gauge{}
+	}
+	httpResponsesCounter, err = meter.Int64Counter("speedtest.http.responses", metric.WithDescription("HTTP responses by status code"))
+	if instrumentFailed(&errs, "HTTP responses counter", err) {
+		httpResponsesCounter = noop.Int64Counter{}
+	}
+	staleCounter, err = meter.Int64Counter("speedtest.results.stale", metric.WithDescription("Results skipped for being older than STW_MAX_RESULT_AGE"))
+	if instrumentFailed(&errs, "stale results counter", err) {
+		staleCounter = noop.Int64Counter{}
+	}
+	filteredCounter, err = meter.Int64Counter("speedtest.results.filtered", metric.WithDescription("Results skipped because their service is not in STW_ALLOWED_SERVICES"))
+	if instrumentFailed(&errs, "filtered results counter", err) {
+		filteredCounter = noop.Int64Counter{}
+	}
+	instrumentFailed(&errs, "seconds since last result gauge", registerLastSeenGauge())
+	if th := thresholds(); th.QualityGood != nil || th.QualityOK != nil {
+		instrumentFailed(&errs, "good streak gauge", registerGoodStreakGauge())
+	}
+	if settings.DownloadRangeWindow > 0 {
+		instrumentFailed(&errs, "download min/max gauges", registerDownloadRangeGauges())
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if settings.OtelRequired {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		log.Errorf("Metric disabled, %v", err)
+	}
+	return nil
+}
+
+// instrumentFailed collects err, if set, as the failure to create what and
+// reports whether it did.
+func instrumentFailed(errs *[]error, what string, err error) bool {
+	if err == nil {
+		return false
+	}
+	*errs = append(*errs, fmt.Errorf("failed to create %s: %w", what, err))
+	return true
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// jsonlQueueSize bounds the results waiting to be written.
+const jsonlQueueSize = 1000
+
+// jsonlConfig configures the JSON-lines file sink.
+type jsonlConfig struct {
+	Path string
+	// MaxBytes rotates the file once it reaches this size; 0 disables it.
+	MaxBytes int64
+	// MaxAge rotates the file once it is this old; 0 disables it.
+	MaxAge time.Duration
+	// MaxFiles is how many rotated files are kept next to the current one.
+	MaxFiles int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// jsonlSink appends every result as a JSON line to a local file, rotating it by
+// size and age. Writes happen on a background goroutine so a slow disk doesn't
+// hold up webhooks.
+type jsonlSink struct {
+	cfg jsonlConfig
+
+	// mu guards closed, so Send never writes to a closed queue.
+	mu     sync.RWMutex
+	closed bool
+	queue  chan []byte
+
+	stopped chan struct{}
+
+	// Only used by the writer goroutine.
+	file     *os.File
+	buf      *bufio.Writer
+	size     int64
+	openedAt time.Time
+}
+
+// newJSONLSink opens cfg.Path for appending and starts the writer.
+func newJSONLSink(cfg jsonlConfig) (*jsonlSink, error) {
+	s := &jsonlSink{
+		cfg:     cfg,
+		queue:   make(chan []byte, jsonlQueueSize),
+		stopped: make(chan struct{}),
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *jsonlSink) Name() string   { return "jsonl" }
+func (s *jsonlSink) Kind() string   { return "jsonl" }
+func (s *jsonlSink) Target() string { return s.cfg.Path }
+
+// Send queues the result for writing.
+func (s *jsonlSink) Send(ctx context.Context, res storedResult) error {
+	line, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return errors.New("jsonl sink is closed")
+	}
+	select {
+	case s.queue <- line:
+		return nil
+	default:
+		return fmt.Errorf("jsonl queue full, dropping result")
+	}
+}
+
+// Close writes the queued results, then flushes and closes the file.
+func (s *jsonlSink) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return errors.Join(s.buf.Flush(), s.file.Close())
+}
+
+func (s *jsonlSink) run() {
+	defer close(s.stopped)
+	for line := range s.queue {
+		if err := s.write(line); err != nil {
+			log.WithField("sink", s.Name()).Errorf("Could not write result to %s: %v", s.cfg.Path, err)
+		}
+		// Flush once the queue is drained, so bursts share a write.
+		if len(s.queue) == 0 {
+			if err := s.buf.Flush(); err != nil {
+				log.WithField("sink", s.Name()).Errorf("Could not write %s: %v", s.cfg.Path, err)
+			}
+		}
+	}
+}
+
+// write appends a line, rotating first if it would exceed the limits.
+func (s *jsonlSink) write(line []byte) error {
+	full := s.cfg.MaxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.cfg.MaxBytes
+	old := s.cfg.MaxAge > 0 && time.Since(s.openedAt) >= s.cfg.MaxAge
+	if full || old {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotating: %w", err)
+		}
+	}
+	n, err := s.buf.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// open opens the current file for appending.
+func (s *jsonlSink) open() error {
+	f, err := os.OpenFile(s.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.buf, s.size = f, bufio.NewWriter(f), info.Size()
+	// An existing file's age is counted from its last write, a close proxy for
+	// when it was started that survives restarts.
+	s.openedAt = time.Now()
+	if info.Size() > 0 {
+		s.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// rotate renames the current file to path.1 (path.1.gz when compressing),
+// shifting older ones up and deleting those beyond MaxFiles, and reopens path.
+func (s *jsonlSink) rotate() error {
+	if err := errors.Join(s.buf.Flush(), s.file.Close()); err != nil {
+		return err
+	}
+	ext := ""
+	if s.cfg.Compress {
+		ext = ".gz"
+	}
+	rotated := func(i int) string { return fmt.Sprintf("%s.%d%s", s.cfg.Path, i, ext) }
+	if err := os.Remove(rotated(s.cfg.MaxFiles)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := s.cfg.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotated(i), rotated(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if s.cfg.MaxFiles == 0 {
+		if err := os.Remove(s.cfg.Path); err != nil {
+			return err
+		}
+	} else if s.cfg.Compress {
+		if err := gzipFile(s.cfg.Path, rotated(1)); err != nil {
+			return err
+		}
+	} else if err := os.Rename(s.cfg.Path, rotated(1)); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// gzipFile compresses src into dst and removes src.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := errors.Join(zw.Close(), out.Close()); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
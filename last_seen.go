@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// lastSeenMaxServers bounds how many servers speedtest.seconds_since_last_result
+// reports; results of further servers are not tracked.
+const lastSeenMaxServers = 1000
+
+type lastSeenKey struct {
+	tenant   string
+	serverID int
+}
+
+type lastSeenEntry struct {
+	at         time.Time
+	serverName string
+}
+
+// lastSeen records when each server last delivered a result.
+type lastSeen struct {
+	mu      sync.Mutex
+	servers map[lastSeenKey]lastSeenEntry
+}
+
+var serverLastSeen = &lastSeen{servers: make(map[lastSeenKey]lastSeenEntry)}
+
+// observe marks p's server as seen now.
+func (l *lastSeen) observe(tenant string, p WebhookPayload) {
+	key := lastSeenKey{tenant, p.ServerID}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.servers[key]; !ok && len(l.servers) >= lastSeenMaxServers {
+		return
+	}
+	l.servers[key] = lastSeenEntry{at: time.Now(), serverName: p.ServerName}
+}
+
+// reset forgets every server.
+func (l *lastSeen) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.servers)
+}
+
+// registerLastSeenGauge registers the speedtest.seconds_since_last_result
+// observable gauge, reporting every tracked server at each collection.
+func registerLastSeenGauge() error {
+	_, err := meter.Float64ObservableGauge("speedtest.seconds_since_last_result",
+		metric.WithDescription("Time since the server last delivered a result"),
+		metric.WithUnit("s"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			now := time.Now()
+			serverLastSeen.mu.Lock()
+			defer serverLastSeen.mu.Unlock()
+			for key, e := range serverLastSeen.servers {
+				attrs := []attribute.KeyValue{
+					attribute.String("server.id", strconv.Itoa(key.serverID)),
+					attribute.String("server.name", e.serverName),
+				}
+				attrs = append(attrs, tenantAttributes(key.tenant)...)
+				o.Observe(now.Sub(e.at).Seconds(), metric.WithAttributes(renameAttributes(attrs)...))
+			}
+			return nil
+		}),
+	)
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Values of STW_LOG_COLOR.
+const (
+	logColorAuto   = "auto"
+	logColorAlways = "always"
+	logColorNever  = "never"
+)
+
+// configureLogging applies the logging settings to the standard logrus logger,
+// opening STW_LOG_FILE if set.
+func configureLogging(s *Settings) error {
+	log.SetLevel(s.LogLevel)
+	// With neither flag set, the text formatter colors output only on a TTY.
+	log.SetFormatter(&log.TextFormatter{
+		ForceColors:   s.LogColor == logColorAlways,
+		DisableColors: s.LogColor == logColorNever,
+	})
+	if s.LogFile == "" {
+		return nil
+	}
+	f, err := openLogFile(s.LogFile)
+	if err != nil {
+		return fmt.Errorf("opening STW_LOG_FILE: %w", err)
+	}
+	logFile = &reopenableFile{path: s.LogFile, f: f}
+	log.SetOutput(logFile)
+	return nil
+}
+
+// logFile is the STW_LOG_FILE output, or nil when logging to stderr.
+var logFile *reopenableFile
+
+// reopenableFile is a log file that can be reopened under the same path after
+// an external tool such as logrotate has moved it.
+type reopenableFile struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+}
+
+func (r *reopenableFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Write(p)
+}
+
+// Reopen switches to a freshly opened file at the path. On error the current
+// file is kept.
+func (r *reopenableFile) Reopen() error {
+	f, err := openLogFile(r.path)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	old := r.f
+	r.f = f
+	r.mu.Unlock()
+	return old.Close()
+}
+
+// reopenLogFile reopens STW_LOG_FILE, as on SIGHUP.
+func reopenLogFile() {
+	if err := logFile.Reopen(); err != nil {
+		log.Errorf("Could not reopen log file %s: %v", logFile.path, err)
+		return
+	}
+	log.Infof("Reopened log file %s", logFile.path)
+}
+
+// loadLoggingSettings reads the logging settings into s.
+func loadLoggingSettings(s *Settings) error {
+	var err error
+	s.LogLevel = log.InfoLevel
+	if raw := strings.TrimSpace(os.Getenv("STW_LOG_LEVEL")); raw != "" {
+		if s.LogLevel, err = log.ParseLevel(raw); err != nil {
+			return fmt.Errorf("invalid value for env var STW_LOG_LEVEL %s", raw)
+		}
+	}
+
+	// NO_COLOR (https://no-color.org) turns auto into never; an explicit
+	// STW_LOG_COLOR=always still wins.
+	switch raw := strings.ToLower(strings.TrimSpace(os.Getenv("STW_LOG_COLOR"))); raw {
+	case "", logColorAuto:
+		s.LogColor = logColorAuto
+		if os.Getenv("NO_COLOR") != "" {
+			s.LogColor = logColorNever
+		}
+	case logColorAlways, logColorNever:
+		s.LogColor = raw
+	default:
+		return fmt.Errorf("invalid value for env var STW_LOG_COLOR %s: must be always, auto or never", raw)
+	}
+
+	s.LogFile = strings.TrimSpace(os.Getenv("STW_LOG_FILE"))
+
+	if s.LogRawBody, err = envBool("STW_LOG_RAW_BODY", false); err != nil {
+		return err
+	}
+	if s.LogRawBodyMax, err = envInt("STW_LOG_RAW_BODY_MAX", 4096); err != nil {
+		return err
+	}
+	if s.LogRawBodyMax < 1 {
+		return fmt.Errorf("invalid value for env var STW_LOG_RAW_BODY_MAX %d: must be at least 1", s.LogRawBodyMax)
+	}
+	return nil
+}
+
+// sensitiveKey matches JSON object keys whose values are redacted from logged bodies.
+var sensitiveKey = regexp.MustCompile(`(?i)(pass(word)?|secret|token|api[-_]?key|auth(orization)?|credential)`)
+
+// logRawBody logs body at debug level for STW_LOG_RAW_BODY, redacting secrets
+// and truncating it to STW_LOG_RAW_BODY_MAX bytes.
+func logRawBody(logger *log.Entry, body []byte) {
+	if !settings.LogRawBody || !logger.Logger.IsLevelEnabled(log.DebugLevel) {
+		return
+	}
+	text := redactBody(body)
+	if len(text) > settings.LogRawBodyMax {
+		logger.Debugf("Raw request body (truncated from %d bytes): %s", len(text), text[:settings.LogRawBodyMax])
+		return
+	}
+	logger.Debugf("Raw request body: %s", text)
+}
+
+// redactBody masks the values of sensitive keys when body is JSON, and any
+// configured secret that appears verbatim.
+func redactBody(body []byte) string {
+	text := string(body)
+	var doc any
+	if json.Unmarshal(body, &doc) == nil {
+		if out, err := json.Marshal(redactJSON(doc)); err == nil {
+			text = string(out)
+		}
+	}
+	for _, secret := range []string{settings.WebhookSecret, settings.AuthQueryToken, settings.AdminToken, settings.AdminPassword, settings.TrackerAPIToken} {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, redacted)
+		}
+	}
+	return text
+}
+
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if sensitiveKey.MatchString(k) {
+				v[k] = redacted
+			} else {
+				v[k] = redactJSON(child)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = redactJSON(child)
+		}
+	}
+	return v
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -19,6 +21,7 @@ import (
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -41,30 +44,95 @@ type Config struct {
 
 // WebhookPayload defines the structure of the incoming JSON from the speedtest service.
 type WebhookPayload struct {
-	ResultID     int     `json:"result_id"`
-	SiteName     string  `json:"site_name"`
-	Service      string  `json:"service"`
-	ServerName   string  `json:"serverName"`
-	ServerID     int     `json:"serverId"`
-	ISP          string  `json:"isp"`
-	Ping         float64 `json:"ping"`
-	Download     float64 `json:"download"`
-	Upload       float64 `json:"upload"`
-	PacketLoss   float64 `json:"packetLoss"`
-	SpeedtestURL string  `json:"speedtest_url"`
-	URL          string  `json:"url"`
+	ResultID   int       `json:"result_id"`
+	SiteName   string    `json:"site_name"`
+	Service    string    `json:"service"`
+	ServerName string    `json:"serverName"`
+	ServerID   int       `json:"serverId"`
+	ISP        string    `json:"isp"`
+	Ping       flexFloat `json:"ping"`
+	Download   flexFloat `json:"download"`
+	Upload     flexFloat `json:"upload"`
+	// PacketLoss is nil when the payload omits it; see STW_MISSING_PACKET_LOSS.
+	PacketLoss   *flexFloat `json:"packetLoss,omitempty"`
+	SpeedtestURL string     `json:"speedtest_url"`
+	URL          string     `json:"url"`
+	// Status and Successful are optional; when present they flag failed tests.
+	Status     string `json:"status,omitempty"`
+	Successful *bool  `json:"successful,omitempty"`
+	// DownloadLatency and UploadLatency are the optional latencies under load, in ms.
+	DownloadLatency *flexFloat `json:"downloadLatency,omitempty"`
+	UploadLatency   *flexFloat `json:"uploadLatency,omitempty"`
+	// Timestamp is the optional time the test ran.
+	Timestamp *resultTimestamp `json:"timestamp,omitempty"`
+	// ServerLocation, ServerCountry and ServerDistance (km) optionally describe the test server.
+	ServerLocation string     `json:"serverLocation,omitempty"`
+	ServerCountry  string     `json:"serverCountry,omitempty"`
+	ServerDistance *flexFloat `json:"serverDistance,omitempty"`
+	// ConnectionType optionally overrides STW_CONNECTION_TYPE for this result.
+	ConnectionType string `json:"connectionType,omitempty"`
+	// Interface is the optional source interface or VLAN on multi-interface routers.
+	// Speedtest Tracker doesn't send it; it comes from custom webhook bodies.
+	Interface string `json:"interface,omitempty"`
+	// Schedule names the scheduler that triggered the test (e.g. hourly,
+	// manual) and JobID identifies the run; both are optional.
+	Schedule string `json:"schedule,omitempty"`
+	JobID    string `json:"jobId,omitempty"`
+	// PublicIP is the optional public address of the tested connection, used
+	// for the STW_GEOIP_DB lookup.
+	PublicIP string `json:"publicIp,omitempty"`
+	// Jitter is the optional ping jitter, in ms.
+	Jitter *flexFloat `json:"jitter,omitempty"`
+	// Duration (seconds) and Elapsed (milliseconds, as in Ookla's CLI output)
+	// optionally give how long the test ran; Duration wins when both are set.
+	// Speedtest Tracker doesn't send either by default.
+	Duration *flexFloat `json:"duration,omitempty"`
+	Elapsed  *flexFloat `json:"elapsed,omitempty"`
+	// Extra holds the STW_EXTRA_NUMERIC_FIELDS values by metric name.
+	Extra map[string]float64 `json:"-"`
 }
 
 // --- Global OTel Variables ---
 
 var (
-	tracer            trace.Tracer
-	meter             metric.Meter
-	pingHistogram     metric.Float64Histogram
-	downloadHistogram metric.Float64Histogram
-	uploadHistogram   metric.Float64Histogram
+	tracer               trace.Tracer
+	meter                metric.Meter
+	pingHistogram        metric.Float64Histogram
+	downloadHistogram    metric.Float64Histogram
+	uploadHistogram      metric.Float64Histogram
+	durationHistogram    metric.Float64Histogram
+	jitterRatioHistogram metric.Float64Histogram
+	ingestDelayHistogram metric.Float64Histogram
+	ingestBytesCounter   metric.Int64Counter
+	skippedCounter       metric.Int64Counter
+	suppressedCounter    metric.Int64Counter
+	resultsCounter       metric.Int64Counter
+	bufferbloatGauge     metric.Int64Gauge
+	qualityCounter       metric.Int64Counter
+	planRatioGauge       metric.Float64Gauge
+	qualityScoreGauge    metric.Float64Gauge
+	alertsCounter        metric.Int64Counter
+	deviationGauge       metric.Float64Gauge
+	// httpResponsesCounter counts responses of every listener by status code.
+	httpResponsesCounter metric.Int64Counter
+	staleCounter         metric.Int64Counter
+	filteredCounter      metric.Int64Counter
+	// alertsSuppressedCounter counts breaches within STW_ALERT_COOLDOWN.
+	alertsSuppressedCounter metric.Int64Counter
 )
 
+// history keeps the most recent results in memory; nil when STW_HISTORY_SIZE is 0.
+var history *resultHistory
+
+// settings holds the runtime options loaded at startup.
+var settings *Settings
+
+// ready reports whether the server should receive traffic; it flips to false when shutdown starts.
+var ready atomic.Bool
+
+// webhookCount counts received webhooks, used to downsample metric recording.
+var webhookCount atomic.Uint64
+
 // --- OTel Initialization ---
 
 // apiKeyCredentials implements credentials.PerRPCCredentials for adding the New Relic API key.
@@ -101,49 +169,179 @@ func run() error {
 	ctx, ctxCan := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer ctxCan()
 
-	// Set up OpenTelemetry.
-	otelShutdown, err := setupOTelSDK(ctx)
+	var err error
+	settings, err = loadSettings()
 	if err != nil {
 		return err
 	}
+	if err := configureLogging(settings); err != nil {
+		return err
+	}
+	if settings.FlushPerRequest {
+		log.Warn("STW_FLUSH_PER_REQUEST is enabled: every webhook triggers a metrics export, which adds latency and export overhead; use it for setup and low-volume instances only")
+	}
+
+	// Set up OpenTelemetry. With STW_NO_EXPORT the global no-op providers stay
+	// in place, so payloads are still parsed and validated but nothing is exported.
+	otelShutdown := func(context.Context) error { return nil }
+	if settings.NoExport {
+		log.Warn("STW_NO_EXPORT is enabled: results are parsed and validated but no telemetry is recorded or exported")
+	} else if otelShutdown, err = setupOTelSDK(ctx, settings); err != nil {
+		if settings.OtelRequired {
+			return err
+		}
+		log.Errorf("OpenTelemetry setup failed, running without telemetry and retrying every %s: %v", settings.OtelRetryInterval, err)
+		otelShutdown = startOTelRetrier(settings, settings.OtelRetryInterval).Shutdown
+	}
 	// Handle shutdown properly so nothing leaks.
 	defer func() {
 		err = errors.Join(err, otelShutdown(context.Background()))
 	}()
 
+	if settings.ExportGate {
+		metricsExportGate.OpenAfter(settings.ExportGateTimeout)
+	}
+	if settings.CardinalityReportInterval > 0 {
+		cardinality = newCardinalityTracker()
+		go cardinality.Report(ctx, settings.CardinalityReportInterval)
+	}
+
 	tracer = otel.Tracer("speedtest-webhook/tracer")
 	meter = otel.Meter("speedtest-webhook/meter")
 
-	pingHistogram, err = meter.Float64Histogram("speedtest.ping", metric.WithDescription("Ping latency"), metric.WithUnit("ms"))
-	if err != nil {
-		log.Fatalf("Failed to create ping histogram: %v", err)
+	if err := createInstruments(); err != nil {
+		return err
 	}
-	downloadHistogram, err = meter.Float64Histogram("speedtest.download", metric.WithDescription("Download speed"), metric.WithUnit("bps"))
-	if err != nil {
-		log.Fatalf("Failed to create download histogram: %v", err)
+
+	if settings.RulesFile != "" {
+		rf, err := loadRulesFile(settings.RulesFile)
+		if err != nil {
+			return err
+		}
+		activeRules.Store(rf)
+		log.Infof("Loaded %d alert rules from %s; send SIGHUP to reload", len(rf.Rules), settings.RulesFile)
 	}
-	uploadHistogram, err = meter.Float64Histogram("speedtest.upload", metric.WithDescription("Upload speed"), metric.WithUnit("bps"))
-	if err != nil {
-		log.Fatalf("Failed to create upload histogram: %v", err)
+	// SIGHUP reopens the log file for logrotate and reloads the alert rules
+	// and thresholds.
+	if settings.LogFile != "" || settings.RulesFile != "" || settings.ThresholdsFile != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if settings.LogFile != "" {
+					reopenLogFile()
+				}
+				if settings.RulesFile != "" {
+					reloadRules()
+				}
+				if settings.ThresholdsFile != "" {
+					reloadThresholds()
+				}
+			}
+		}()
+	}
+
+	if settings.GeoIPDB != "" {
+		openGeoIP(settings.GeoIPDB)
 	}
 
-	portRaw := os.Getenv("STW_SERVER_PORT")
-	if portRaw == "" {
-		return fmt.Errorf("missing env var STW_SERVER_PORT")
+	if settings.RemoteWrite.URL != "" {
+		registerSink(newRemoteWriteSink(settings.RemoteWrite))
+	}
+	if settings.Statsd.Addr != "" {
+		sink, err := newStatsdSink(settings.Statsd)
+		if err != nil {
+			return fmt.Errorf("opening STW_STATSD_ADDR: %w", err)
+		}
+		registerSink(sink)
+	}
+	if settings.JSONL.Path != "" {
+		sink, err := newJSONLSink(settings.JSONL)
+		if err != nil {
+			return fmt.Errorf("opening STW_JSONL_PATH: %w", err)
+		}
+		registerSink(sink)
+	}
+	for _, t := range settings.ForwardTargets {
+		registerSink(newForwardSink(t, settings.ForwardSecret))
+	}
+	if settings.SinkStateFile != "" {
+		if err := loadSinkState(settings.SinkStateFile); err != nil {
+			return err
+		}
 	}
 
-	port, err := strconv.Atoi(portRaw)
+	var cfg *Config
+	if settings.ConfigFile != "" {
+		if cfg, err = loadConfigFile(settings.ConfigFile); err != nil {
+			return err
+		}
+	}
+	port, portSource, err := resolvePort(cfg)
 	if err != nil {
-		return fmt.Errorf("inavlid value for env var STW_SERVER_PORT %s", portRaw)
+		return err
 	}
+	log.Infof("Using port %d from %s", port, portSource)
 
 	mux := http.NewServeMux()
-	otelWebhook := otelhttp.WithRouteTag("/webhook", http.HandlerFunc(webhookHandler))
+	otelWebhook := otelhttp.WithRouteTag("/webhook", withRequestID(withCORS(http.HandlerFunc(webhookHandler), http.MethodPost)))
 	mux.Handle("/webhook", otelWebhook)
+	mux.Handle("/webhook/{tenant}", otelhttp.WithRouteTag("/webhook/{tenant}", withRequestID(withCORS(http.HandlerFunc(webhookHandler), http.MethodPost))))
+
+	// With STW_ADMIN_ADDR set, everything but /webhook moves to a separate internal
+	// listener that also serves pprof and the effective configuration.
+	internal := mux
+	if settings.AdminAddr != "" {
+		internal = http.NewServeMux()
+		registerInternalOnlyRoutes(internal)
+	}
+	internal.HandleFunc("/readyz", readyzHandler)
+	internal.HandleFunc("/metrics/openmetrics", openMetricsHandler)
+	internal.HandleFunc("/status", statusHandler)
+	internal.HandleFunc("/health/detail", healthDetailHandler)
+
+	if settings.HistorySize > 0 {
+		history = newResultHistory(settings.HistorySize)
+		internal.Handle("/results", otelhttp.WithRouteTag("/results", withCORS(http.HandlerFunc(resultsHandler), http.MethodGet)))
+		internal.Handle("/results.csv", otelhttp.WithRouteTag("/results.csv", withCORS(http.HandlerFunc(resultsCSVHandler), http.MethodGet)))
+	}
+	// /run-test spends the stored tracker token, so it is only served behind
+	// the admin credentials on the internal listener.
+	if settings.TrackerAPIURL != "" {
+		if adminAuthEnabled() && settings.AdminAddr != "" {
+			internal.Handle("/run-test", otelhttp.WithRouteTag("/run-test", withRequestID(withAdminAuth(http.HandlerFunc(runTestHandler)))))
+		} else {
+			log.Warn("Not serving /run-test: it requires STW_ADMIN_ADDR and STW_ADMIN_TOKEN or STW_ADMIN_USER")
+		}
+	}
+	if adminAuthEnabled() {
+		internal.Handle("/admin/sinks", otelhttp.WithRouteTag("/admin/sinks", withRequestID(withAdminAuth(http.HandlerFunc(adminSinksHandler)))))
+		if history != nil {
+			internal.Handle("/admin/replay", otelhttp.WithRouteTag("/admin/replay", withRequestID(withAdminAuth(http.HandlerFunc(adminReplayHandler)))))
+		}
+		if settings.AllowReset {
+			internal.Handle("/admin/reset", otelhttp.WithRouteTag("/admin/reset", withRequestID(withAdminAuth(http.HandlerFunc(adminResetHandler)))))
+		}
+	}
+	if settings.DashboardEnabled {
+		internal.Handle("/{$}", otelhttp.WithRouteTag("/", dashboardHandler()))
+	}
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: otelhttp.NewHandler(mux, "/"),
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   otelhttp.NewHandler(withResponseCounter(withResponseHeaders(mux)), "/"),
+		TLSConfig: serverTLSConfig(),
+	}
+	servers := []*http.Server{server}
+
+	var adminServer *http.Server
+	if settings.AdminAddr != "" {
+		adminServer = &http.Server{
+			Addr:      settings.AdminAddr,
+			Handler:   otelhttp.NewHandler(withResponseCounter(withResponseHeaders(internal)), "/"),
+			TLSConfig: serverTLSConfig(),
+		}
+		servers = append(servers, adminServer)
 	}
 
 	// --- Graceful Shutdown ---
@@ -152,18 +350,43 @@ func run() error {
 
 	go func() {
 		log.Infof("Server starting on port %d", port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := listenAndServe(server); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Could not listen on port %d: %v\n", port, err)
 		}
 	}()
+	if adminServer != nil {
+		go func() {
+			log.Infof("Internal server starting on %s", adminServer.Addr)
+			if err := listenAndServe(adminServer); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Could not listen on %s: %v\n", adminServer.Addr, err)
+			}
+		}()
+	}
 
+	ready.Store(true)
 	<-stop
 
+	log.Info("Shutdown started, reporting unready")
+	ready.Store(false)
+	if settings.DrainDelay > 0 {
+		log.Infof("Draining for %s so load balancers can deregister", settings.DrainDelay)
+		time.Sleep(settings.DrainDelay)
+	}
+
 	log.Println("Shutting down server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if settings.ExportGate {
+		metricsExportGate.Close(ctx)
+	}
+	closeAlerts(ctx)
+	if err := closeSinks(ctx); err != nil {
 		return err
 	}
 
@@ -172,55 +395,627 @@ func run() error {
 	return nil
 }
 
+// listenAndServe serves srv over HTTPS when TLS is configured, plain HTTP otherwise.
+func listenAndServe(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS(settings.TLSCertFile, settings.TLSKeyFile)
+	}
+	return srv.ListenAndServe()
+}
+
+// readyzHandler reports 200 while the server accepts traffic and 503 once shutdown has started.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
 // webhookHandler processes incoming POST requests.
 func webhookHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	allowed := []string{http.MethodPost, http.MethodOptions}
+	if settings.WebhookHead {
+		allowed = append(allowed, http.MethodHead)
+	}
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodOptions:
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodHead:
+		// Availability checks from uptime monitors; nothing is recorded.
+		if !settings.WebhookHead {
+			writeMethodNotAllowed(w, allowed...)
+			return
+		}
+		if tenant := r.PathValue("tenant"); tenant != "" && !tenantAllowed(tenant) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		writeMethodNotAllowed(w, allowed...)
 		return
 	}
 
-	ctx, span := tracer.Start(r.Context(), "handleWebhookRequest")
+	// otelhttp has already extracted any incoming traceparent/baggage into the
+	// request context, so this span joins the upstream trace when there is one.
+	ctx, span := tracer.Start(r.Context(), settings.SpanName)
 	defer span.End()
+	ctx, phases := withRequestPhases(ctx)
+	defer phases.logIfSlow(ctx)
+	span.SetAttributes(attribute.String("request_id", requestIDFrom(ctx)))
+	// Only set on the /webhook/{tenant} route.
+	tenant := r.PathValue("tenant")
+	if tenant != "" {
+		if !tenantAllowed(tenant) {
+			http.Error(w, "Unknown tenant", http.StatusNotFound)
+			return
+		}
+		span.SetAttributes(renameAttributes(tenantAttributes(tenant))...)
+	}
+	var client string
+	if settings.RecordClient {
+		client = clientOf(r.UserAgent())
+		span.SetAttributes(renameAttributes(clientAttributes(client))...)
+	}
+	span.SetAttributes(settings.SpanAttributes...)
+	if settings.SpanHTTPMetadata {
+		span.SetAttributes(
+			attribute.String("user_agent.original", r.UserAgent()),
+			attribute.Int64("http.request.body.size", r.ContentLength),
+		)
+	}
+	logger := logFrom(ctx)
+
+	if settings.AuthQueryParam != "" && !checkQueryToken(r) {
+		logger.Warnf("Rejecting webhook with missing or invalid %s query token", settings.AuthQueryParam)
+		span.AddEvent("speedtest.query_token.invalid")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-	body, err := io.ReadAll(r.Body)
+	var body []byte
+	var release func()
+	var err error
+	if streamable(r) {
+		var src io.Reader
+		var closeBody func()
+		if src, closeBody, err = openBody(w, r, settings.MaxStreamBytes); err != nil {
+			span.RecordError(err)
+			status, msg := bodyErrorResponse(err)
+			http.Error(w, msg, status)
+			return
+		}
+		defer closeBody()
+		br := bufio.NewReader(src)
+		if startsWithArray(br) {
+			counted := &countingReader{r: br}
+			streamBatch(ctx, w, counted, tenant, client)
+			recordIngestBytes(ctx, counted.n, tenant, "")
+			return
+		}
+		// Anything but a batch is held to STW_MAX_BODY_BYTES, as if buffered.
+		body, release, err = bufferBody(http.MaxBytesReader(w, io.NopCloser(br), settings.MaxBodyBytes))
+	} else {
+		body, release, err = readBody(w, r)
+	}
 	if err != nil {
 		span.RecordError(err)
-		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		status, msg := bodyErrorResponse(err)
+		http.Error(w, msg, status)
+		return
+	}
+	defer release()
+	phases.lap("read")
+
+	if settings.WebhookSecret != "" && !validSignature(settings.SignatureFormat, []byte(settings.WebhookSecret), body, r.Header.Get(settings.SignatureHeader)) {
+		logger.Warnf("Rejecting webhook with missing or invalid %s signature", settings.SignatureHeader)
+		span.AddEvent("speedtest.signature.invalid")
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
 		return
 	}
+	logRawBody(logger, body)
 
-	var payload WebhookPayload
-	if err := json.Unmarshal(body, &payload); err != nil {
+	payloads, dropped, err := decodePayloads(r.Header.Get("Content-Type"), body)
+	// Every result is prepared before any is recorded, so a batch is either
+	// accepted or rejected as a whole.
+	for i := 0; err == nil && i < len(payloads); i++ {
+		payloads[i], err = preparePayload(payloads[i])
+	}
+	// Rejected bodies are counted without a site, so they never claim one of
+	// the STW_MAX_SITES meters.
+	site := ""
+	if err == nil && len(payloads) == 1 {
+		site = payloads[0].SiteName
+	}
+	recordIngestBytes(ctx, int64(len(body)), tenant, site)
+	if err != nil {
 		span.RecordError(err)
-		http.Error(w, "Error parsing JSON payload", http.StatusBadRequest)
+		status, msg := decodeErrorResponse(logger, err)
+		http.Error(w, msg, status)
 		return
 	}
+	phases.lap("decode")
+	if len(payloads) > 1 {
+		logger.Infof("Received batch of %d results", len(payloads))
+		span.SetAttributes(attribute.Int("speedtest.batch.size", len(payloads)))
+	}
+	if dropped > 0 {
+		logger.Warnf("Batch exceeds STW_MAX_BATCH_SIZE, dropped the last %d of %d results", dropped, len(payloads)+dropped)
+		span.SetAttributes(attribute.Int("speedtest.batch.dropped", dropped))
+	}
 
-	log.Printf("Received speedtest result for server ID: %d", payload.ServerID)
+	events := newSpanEvents(span, settings.MaxSpanEvents, len(payloads))
+	tally := resultTally{dropped: dropped}
+	for _, payload := range payloads {
+		tally.add(processResult(ctx, events, tenant, client, payload))
+	}
+	respondResults(ctx, w, events, tally)
+}
 
-	metricOpts := metric.WithAttributes(
-		attribute.String("server.id", strconv.Itoa(payload.ServerID)),
-		attribute.String("server.name", payload.ServerName),
-		attribute.String("isp", payload.ISP),
-	)
-	pingHistogram.Record(ctx, payload.Ping, metricOpts)
-	downloadHistogram.Record(ctx, payload.Download, metricOpts)
-	uploadHistogram.Record(ctx, payload.Upload, metricOpts)
+// recordIngestBytes adds a webhook body's size to speedtest.ingest_bytes,
+// tagged with the tenant and, for single results under STW_PER_SITE_METERS,
+// the site within STW_MAX_SITES.
+func recordIngestBytes(ctx context.Context, n int64, tenant, site string) {
+	attrs := renameAttributes(tenantAttributes(tenant))
+	if site != "" && histogramsForSite(site) != nil {
+		attrs = append(attrs, attribute.String("site_name", site))
+	}
+	ingestBytesCounter.Add(ctx, n, metric.WithAttributes(attrs...))
+}
+
+// skipReason says why a result was accepted without being recorded.
+type skipReason string
+
+const (
+	skipNone skipReason = ""
+	// skipStale marks a result older than STW_MAX_RESULT_AGE.
+	skipStale skipReason = "stale"
+	// skipFiltered marks a result from a service not in STW_ALLOWED_SERVICES.
+	skipFiltered skipReason = "filtered"
+)
+
+// resultTally counts the results of a webhook request.
+type resultTally struct {
+	results, stale, filtered int
+	// dropped counts the results beyond STW_MAX_BATCH_SIZE, which are not
+	// processed at all.
+	dropped int
+	// problem is the first reason for an error span status.
+	problem string
+}
+
+func (t *resultTally) add(skip skipReason, problem string) {
+	t.results++
+	switch skip {
+	case skipStale:
+		t.stale++
+	case skipFiltered:
+		t.filtered++
+	default:
+		if t.problem == "" {
+			t.problem = problem
+		}
+	}
+}
+
+// recorded is the number of results that weren't skipped.
+func (t resultTally) recorded() int {
+	return t.results - t.stale - t.filtered
+}
 
-	span.AddEvent("speedtest.result", trace.WithAttributes(
+// respondResults ends a webhook request that recorded results: it adds the
+// span summary and status, flushes if configured and writes the 200 response.
+func respondResults(ctx context.Context, w http.ResponseWriter, events *spanEvents, t resultTally) {
+	span := trace.SpanFromContext(ctx)
+	events.summarize(t)
+	if settings.FlushPerRequest {
+		phases := phasesFrom(ctx)
+		phases.lap("record")
+		flushMetrics(ctx)
+		phases.lap("flush")
+	}
+
+	// The span status reflects the results, independently of the 200 response.
+	if t.problem != "" {
+		span.SetStatus(codes.Error, t.problem)
+	} else if t.recorded() > 0 {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if t.results == 1 && t.dropped == 0 {
+		switch {
+		case t.stale == 1:
+			fmt.Fprintln(w, "Webhook received; stale result not recorded.")
+		case t.filtered == 1:
+			fmt.Fprintln(w, "Webhook received; result from a filtered service not recorded.")
+		default:
+			fmt.Fprintln(w, "Webhook received and processed.")
+		}
+		return
+	}
+	msg := fmt.Sprintf("Webhook received and processed %d results", t.results)
+	if t.stale > 0 {
+		msg += fmt.Sprintf("; %d stale results not recorded", t.stale)
+	}
+	if t.filtered > 0 {
+		msg += fmt.Sprintf("; %d results from filtered services not recorded", t.filtered)
+	}
+	if t.dropped > 0 {
+		msg += fmt.Sprintf("; %d results beyond STW_MAX_BATCH_SIZE dropped", t.dropped)
+	}
+	fmt.Fprintln(w, msg+".")
+}
+
+// bodyErrorResponse maps an error reading the request body to its response.
+func bodyErrorResponse(err error) (int, string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, "Request body too large"
+	}
+	var unsupported *unsupportedMediaError
+	if errors.As(err, &unsupported) {
+		return http.StatusUnsupportedMediaType, "Unsupported " + unsupported.What
+	}
+	if errors.Is(err, errInvalidGzip) {
+		return http.StatusBadRequest, "Error decompressing request body"
+	}
+	return http.StatusInternalServerError, "Error reading request body"
+}
+
+// decodeErrorResponse maps an error decoding or preparing the payloads to its response.
+func decodeErrorResponse(logger *log.Entry, err error) (int, string) {
+	var unsupported *unsupportedMediaError
+	if errors.As(err, &unsupported) {
+		return http.StatusUnsupportedMediaType, "Unsupported " + unsupported.What
+	}
+	var unknown *unknownFieldError
+	if errors.As(err, &unknown) {
+		return http.StatusUnprocessableEntity, "Unexpected field " + unknown.Field + " in JSON payload"
+	}
+	var nonFinite *nonFiniteError
+	if errors.As(err, &nonFinite) {
+		return http.StatusUnprocessableEntity, "Non-finite number " + strconv.Quote(nonFinite.Value) + " in JSON payload"
+	}
+	if errors.Is(err, errTrailingData) {
+		logger.Warn("Rejecting payload with trailing data after the JSON object")
+		return http.StatusBadRequest, "Unexpected data after JSON payload"
+	}
+	if errors.Is(err, errEmptyBatch) {
+		return http.StatusBadRequest, "Empty batch of results"
+	}
+	var tooLarge *batchTooLargeError
+	if errors.As(err, &tooLarge) {
+		logger.Warn(err)
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("Batch exceeds the limit of %d results", tooLarge.Max)
+	}
+	if errors.Is(err, errTransform) {
+		logger.Errorf("STW_TRANSFORM_EXPR failed: %v", err)
+		return http.StatusUnprocessableEntity, "Error transforming payload"
+	}
+	return http.StatusBadRequest, "Error parsing JSON payload"
+}
+
+// preparePayload applies STW_MISSING_PACKET_LOSS=zero, the server name
+// normalization and STW_TRANSFORM_EXPR to a decoded result. Transform failures
+// wrap errTransform.
+func preparePayload(p WebhookPayload) (WebhookPayload, error) {
+	if settings.ServerNameAliases != nil || settings.ServerNameNormalize {
+		p.ServerName = normalizeServerName(p.ServerName)
+	}
+	if p.PacketLoss == nil && settings.MissingPacketLossZero {
+		p.PacketLoss = new(flexFloat)
+	}
+	if settings.Transform != nil {
+		transformed, err := applyTransform(settings.Transform, p)
+		if err != nil {
+			return p, fmt.Errorf("%w: %v", errTransform, err)
+		}
+		p = transformed
+	}
+	return p, nil
+}
+
+// processResult records a single result to metrics, the span, the history and
+// the sinks. It returns why the result was skipped, if it was, and, for a
+// failed test or a breached critical threshold, the reason for an error span
+// status.
+func processResult(ctx context.Context, events *spanEvents, tenant, client string, payload WebhookPayload) (skip skipReason, problem string) {
+	span := trace.SpanFromContext(ctx)
+	logger := logFrom(ctx)
+	logger.Printf("Received speedtest result for server ID: %d", payload.ServerID)
+	markReceived()
+	received := time.Now()
+
+	// Stale results get a 200 so the sender stops retrying, but aren't recorded.
+	if settings.MaxResultAge > 0 && payload.Timestamp != nil {
+		if age := received.Sub(payload.Timestamp.Time); age > settings.MaxResultAge {
+			logger.Warnf("Skipping result %d for server ID %d: %s old exceeds STW_MAX_RESULT_AGE", payload.ResultID, payload.ServerID, age.Round(time.Second))
+			staleCounter.Add(ctx, 1)
+			events.add("speedtest.result.stale", attribute.Int("result_id", payload.ResultID))
+			return skipStale, ""
+		}
+	}
+	if !serviceAllowed(payload.Service) {
+		logger.Infof("Skipping result %d for server ID %d: service %q is not in STW_ALLOWED_SERVICES", payload.ResultID, payload.ServerID, payload.Service)
+		filteredCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("service", payload.Service)))
+		events.add("speedtest.result.filtered", attribute.Int("result_id", payload.ResultID), attribute.String("service", payload.Service))
+		return skipFiltered, ""
+	}
+	serverLastSeen.observe(tenant, payload)
+
+	// Until the first metric export, with STW_EXPORT_GATE, results are held
+	// and recorded once it succeeds, without holding up the response.
+	if settings.ExportGate {
+		held := metricsExportGate.hold(func() {
+			// The request is over: its span has ended and its phases are logged.
+			ctx := context.WithValue(context.WithoutCancel(ctx), requestPhasesKey{}, (*requestPhases)(nil))
+			recordResult(ctx, &spanEvents{span: span}, tenant, client, payload, received)
+		})
+		if held {
+			logger.Infof("Holding result %d for server ID %d until the first metric export", payload.ResultID, payload.ServerID)
+			events.add("speedtest.result.held", attribute.Int("result_id", payload.ResultID))
+			return skipNone, ""
+		}
+	}
+	return skipNone, recordResult(ctx, events, tenant, client, payload, received)
+}
+
+// recordResult is the part of processResult that records an accepted result.
+// It returns the reason for an error span status, if any.
+func recordResult(ctx context.Context, events *spanEvents, tenant, client string, payload WebhookPayload, received time.Time) (problem string) {
+	span := trace.SpanFromContext(ctx)
+	logger := logFrom(ctx)
+	connectionType := connectionTypeOf(payload)
+	derivedAttrs := append(geoAttributes(logger, payload), tenantAttributes(tenant)...)
+	derivedAttrs = append(derivedAttrs, clientAttributes(client)...)
+	locationAttrs := serverLocationAttributes(payload)
+	metricAttrs := metricAttributes(payload, derivedAttrs)
+	metricOpts := metric.WithAttributes(metricAttrs...)
+	if cardinality != nil {
+		cardinality.Observe(append(metricAttrs, attribute.String("site_name", payload.SiteName))...)
+	}
+	outcome := settings.FailureHeuristics.outcome(payload)
+	resultsCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("outcome", outcome)))
+	if d, ok := ingestDelayOf(payload, received); ok {
+		ingestDelayHistogram.Record(ctx, roundValue(d.Seconds()), metricOpts)
+	}
+
+	// Failed tests are only counted, so zeros don't skew the speed histograms.
+	if outcome == outcomeFailure {
+		logger.Warnf("Speedtest result %d for server ID %d is a failed test", payload.ResultID, payload.ServerID)
+		failedVar.Add(1)
+	} else if settings.SuppressIdenticalWindow > 0 && identicalResults.suppress(tenant, payload) {
+		logger.Infof("Not recording result %d for server ID %d: identical to the last recorded result", payload.ResultID, payload.ServerID)
+		suppressedCounter.Add(ctx, 1)
+		suppressedVar.Add(1)
+	} else if n := webhookCount.Add(1); (n-1)%uint64(settings.RecordEveryN) == 0 {
+		recordSpeedHistograms(ctx, payload, metricOpts)
+	} else {
+		skippedCounter.Add(ctx, 1)
+	}
+
+	// Sized for the usual attributes, so appending rarely reallocates.
+	eventAttrs := make([]attribute.KeyValue, 0, 32)
+	eventAttrs = append(eventAttrs,
 		attribute.Int("result_id", payload.ResultID),
 		attribute.String("site_name", payload.SiteName),
 		attribute.String("service", payload.Service),
 		attribute.String("server.name", payload.ServerName),
 		attribute.Int("server.id", payload.ServerID),
 		attribute.String("isp", payload.ISP),
-		attribute.Float64("ping", payload.Ping),
-		attribute.Float64("download.bps", payload.Download),
-		attribute.Float64("upload.bps", payload.Upload),
-		attribute.Float64("packet.loss", payload.PacketLoss),
-		attribute.String("speedtest.url", payload.SpeedtestURL),
-	))
+		attribute.Float64("ping", float64(payload.Ping)),
+		attribute.Float64("download.bps", float64(payload.Download)),
+		attribute.Float64("upload.bps", float64(payload.Upload)),
+		attribute.String("outcome", outcome),
+	)
+	if payload.PacketLoss != nil {
+		eventAttrs = append(eventAttrs, attribute.Float64("packet.loss", float64(*payload.PacketLoss)))
+	}
+	if d, ok := testDurationOf(payload); ok {
+		eventAttrs = append(eventAttrs, attribute.Float64("test.duration_s", d))
+	}
+	if payload.Jitter != nil {
+		eventAttrs = append(eventAttrs, attribute.Float64("ping.jitter", float64(*payload.Jitter)))
+	}
+	if r, ok := jitterRatioOf(payload); ok {
+		eventAttrs = append(eventAttrs, attribute.Float64("ping.jitter_ratio", r))
+	}
+	if connectionType != "" {
+		eventAttrs = append(eventAttrs, attribute.String("connection.type", connectionType))
+	}
+	if payload.Interface != "" {
+		eventAttrs = append(eventAttrs, attribute.String("network.interface.name", payload.Interface))
+	}
+	if payload.Schedule != "" {
+		eventAttrs = append(eventAttrs, attribute.String("speedtest.schedule", payload.Schedule))
+	}
+	// Job IDs are unique per run, so they stay off the metrics.
+	if payload.JobID != "" {
+		eventAttrs = append(eventAttrs, attribute.String("speedtest.job_id", payload.JobID))
+	}
+	eventAttrs = append(eventAttrs, locationAttrs...)
+	eventAttrs = append(eventAttrs, derivedAttrs...)
+	var urlAttrs []attribute.KeyValue
+	if isLinkURL(payload.SpeedtestURL) {
+		urlAttrs = append(urlAttrs, attribute.String("speedtest.url", payload.SpeedtestURL))
+	}
+	if isLinkURL(payload.URL) {
+		urlAttrs = append(urlAttrs, attribute.String("speedtest.result_url", payload.URL))
+	}
+	span.SetAttributes(urlAttrs...)
+	eventAttrs = append(eventAttrs, urlAttrs...)
+	th := thresholds()
+	// Last-value gauges are written together, in STW_GAUGE_WRITE_ORDER.
+	var gaugeWrites []func()
+	if grade, score, ok := bufferbloatGrade(payload, th.BufferbloatThresholds); ok && outcome == outcomeSuccess {
+		gradeAttr := attribute.String("bufferbloat.grade", grade)
+		gaugeWrites = append(gaugeWrites, func() {
+			bufferbloatGauge.Record(ctx, score, metric.WithAttributes(append(metricAttrs, gradeAttr)...))
+		})
+		eventAttrs = append(eventAttrs, gradeAttr)
+	}
+	if th.QualityGood != nil || th.QualityOK != nil {
+		serverGoodStreaks.observe(tenant, payload, outcome)
+	}
+	if tier, ok := qualityTier(payload); ok && outcome == outcomeSuccess {
+		tierAttr := attribute.String("quality.tier", tier)
+		qualityCounter.Add(ctx, 1, metric.WithAttributes(append(metricAttrs, tierAttr)...))
+		eventAttrs = append(eventAttrs, tierAttr)
+	}
+	if ratios, meets, ok := planRatios(payload); ok && outcome == outcomeSuccess {
+		slaAttr := attribute.Bool("meets_sla", meets)
+		for _, r := range ratios {
+			opts := metric.WithAttributes(append(metricAttrs, slaAttr, attribute.String("direction", r.Direction))...)
+			gaugeWrites = append(gaugeWrites, func() { planRatioGauge.Record(ctx, r.Ratio, opts) })
+			eventAttrs = append(eventAttrs, attribute.Float64("plan."+r.Direction+"_ratio", r.Ratio))
+		}
+		eventAttrs = append(eventAttrs, slaAttr)
+	}
+	if settings.QualityScore && outcome == outcomeSuccess {
+		if score, ok := qualityScoreOf(payload); ok {
+			gaugeWrites = append(gaugeWrites, func() { qualityScoreGauge.Record(ctx, score, metricOpts) })
+			scoreAttr := attribute.Float64("speedtest.quality_score", score)
+			span.SetAttributes(scoreAttr)
+			eventAttrs = append(eventAttrs, scoreAttr)
+		}
+	}
+	serverGaugeOrder.apply(logger, tenant, payload, received, gaugeWrites)
+	events.add("speedtest.result", renameAttributes(eventAttrs)...)
+	if outcome == outcomeFailure {
+		problem = "speedtest failed"
+	} else if breaches := th.CriticalThresholds.breaches(payload); len(breaches) > 0 {
+		problem = "critical thresholds breached: " + strings.Join(breaches, ", ")
+	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, "Webhook received and processed.")
+	if outcome == outcomeSuccess {
+		evaluateRules(ctx, tenant, payload, connectionType)
+		if settings.BaselineWindow > 0 {
+			serverBaselines.observe(ctx, tenant, payload, metricAttrs)
+		}
+		if settings.DownloadRangeWindow > 0 {
+			serverDownloadRanges.observe(tenant, payload)
+		}
+	}
+
+	res := storedResult{
+		ReceivedAt:     time.Now(),
+		Outcome:        outcome,
+		Tenant:         tenant,
+		Environment:    settings.Environment,
+		ConnectionType: connectionType,
+		Payload:        payload,
+	}
+	if history != nil {
+		history.Add(res)
+	}
+	sendToSinks(ctx, res)
+	phasesFrom(ctx).lap("record")
+	return problem
+}
+
+// isLinkURL reports whether raw is an absolute http(s) URL worth attaching as a link.
+func isLinkURL(raw string) bool {
+	if raw == "" {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// connectionTypeOf returns the payload connection type, falling back to STW_CONNECTION_TYPE.
+func connectionTypeOf(p WebhookPayload) string {
+	if p.ConnectionType != "" {
+		return p.ConnectionType
+	}
+	return settings.ConnectionType
+}
+
+// metricAttributes returns the attributes recorded with a result's metrics,
+// including derived ones such as the GeoIP location, tenant and client.
+func metricAttributes(p WebhookPayload, derived []attribute.KeyValue) []attribute.KeyValue {
+	attrs := append([]attribute.KeyValue{
+		attribute.String("server.id", strconv.Itoa(p.ServerID)),
+		attribute.String("server.name", p.ServerName),
+		attribute.String("isp", p.ISP),
+	}, settings.StaticMetricAttributes...)
+	if connectionType := connectionTypeOf(p); connectionType != "" {
+		attrs = append(attrs, attribute.String("connection.type", connectionType))
+	}
+	if p.Interface != "" {
+		attrs = append(attrs, attribute.String("network.interface.name", p.Interface))
+	}
+	if p.Schedule != "" {
+		attrs = append(attrs, attribute.String("speedtest.schedule", p.Schedule))
+	}
+	attrs = append(attrs, derived...)
+	if settings.ServerLocationMetricAttributes {
+		for _, a := range serverLocationAttributes(p) {
+			if a.Key != "server.distance_km" {
+				attrs = append(attrs, a)
+			}
+		}
+	}
+	return renameAttributes(attrs)
+}
+
+// recordSpeedHistograms records the ping, download and upload of a successful
+// result, on the site's own meter when STW_PER_SITE_METERS applies.
+func recordSpeedHistograms(ctx context.Context, p WebhookPayload, opts metric.RecordOption) {
+	ping, download, upload := pingHistogram, downloadHistogram, uploadHistogram
+	if h := histogramsForSite(p.SiteName); h != nil {
+		ping, download, upload = h.ping, h.download, h.upload
+	}
+	ping.Record(ctx, roundValue(float64(p.Ping)), opts)
+	download.Record(ctx, roundValue(float64(p.Download)/settings.SpeedUnit.Divisor), opts)
+	upload.Record(ctx, roundValue(float64(p.Upload)/settings.SpeedUnit.Divisor), opts)
+	if d, ok := testDurationOf(p); ok {
+		durationHistogram.Record(ctx, roundValue(d), opts)
+	}
+	if r, ok := jitterRatioOf(p); ok {
+		jitterRatioHistogram.Record(ctx, roundValue(r), opts)
+	}
+	for name, v := range p.Extra {
+		extraHistograms[name].Record(ctx, roundValue(v), opts)
+	}
+}
+
+// testDurationOf returns how long the test ran in seconds, if the payload says.
+func testDurationOf(p WebhookPayload) (float64, bool) {
+	switch {
+	case p.Duration != nil:
+		return float64(*p.Duration), true
+	case p.Elapsed != nil:
+		return float64(*p.Elapsed) / 1000, true
+	}
+	return 0, false
+}
+
+// jitterRatioOf returns the jitter as a fraction of the ping, when the payload
+// has a jitter and a positive ping.
+func jitterRatioOf(p WebhookPayload) (float64, bool) {
+	if p.Jitter == nil || p.Ping <= 0 {
+		return 0, false
+	}
+	return float64(*p.Jitter) / float64(p.Ping), true
+}
+
+// serverLocationAttributes returns the test server location fields present in the payload.
+func serverLocationAttributes(p WebhookPayload) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if p.ServerLocation != "" {
+		attrs = append(attrs, attribute.String("server.location", p.ServerLocation))
+	}
+	if p.ServerCountry != "" {
+		attrs = append(attrs, attribute.String("server.country", p.ServerCountry))
+	}
+	if p.ServerDistance != nil {
+		attrs = append(attrs, attribute.Float64("server.distance_km", float64(*p.ServerDistance)))
+	}
+	return attrs
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+func init() {
+	log.SetLevel(log.WarnLevel)
+}
+
+const testPayload = `{"result_id": 1, "serverId": 7, "serverName": "Example", "isp": "ISP", "ping": 12, "download": 250000000, "upload": 50000000}`
+
+// useSettings loads the settings from the STW_* variables in env for the
+// duration of the test.
+func useSettings(tb testing.TB, env map[string]string) {
+	tb.Helper()
+	for k, v := range env {
+		tb.Setenv(k, v)
+	}
+	s, err := loadSettings()
+	if err != nil {
+		tb.Fatalf("loadSettings: %v", err)
+	}
+	prev := settings
+	settings = s
+	tb.Cleanup(func() { settings = prev })
+}
+
+// useInstruments points the global tracer at tp, or a no-op tracer when nil,
+// and creates the metric instruments on a no-op meter.
+func useInstruments(tb testing.TB, tp trace.TracerProvider) {
+	tb.Helper()
+	if tp == nil {
+		tp = tracenoop.NewTracerProvider()
+	}
+	tracer = tp.Tracer("speedtest-webhook/tracer")
+	meter = noop.NewMeterProvider().Meter("speedtest-webhook/meter")
+	if err := createInstruments(); err != nil {
+		tb.Fatalf("createInstruments: %v", err)
+	}
+}
+
+// postWebhook sends body to webhookHandler through the server's middleware.
+func postWebhook(h http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhookSpanContinuesIncomingTrace(t *testing.T) {
+	useSettings(t, map[string]string{"STW_HISTORY_SIZE": "0"})
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	useInstruments(t, tp)
+	h := otelhttp.NewHandler(withRequestID(http.HandlerFunc(webhookHandler)), "/",
+		otelhttp.WithTracerProvider(tp), otelhttp.WithPropagators(newPropagator()))
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(testPayload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	req.Header.Set("baggage", "source=upstream")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+
+	var server, webhook sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		switch {
+		case s.SpanKind() == trace.SpanKindServer:
+			server = s
+		case s.Name() == settings.SpanName:
+			webhook = s
+		}
+	}
+	if server == nil || webhook == nil {
+		t.Fatalf("missing spans, got %d ended", len(recorder.Ended()))
+	}
+	if got := server.Parent(); !got.IsRemote() || got.TraceID().String() != traceID || got.SpanID().String() != parentID {
+		t.Errorf("server span parent = %s/%s (remote %t), want %s/%s", got.TraceID(), got.SpanID(), got.IsRemote(), traceID, parentID)
+	}
+	if webhook.Parent().SpanID() != server.SpanContext().SpanID() || webhook.SpanContext().TraceID().String() != traceID {
+		t.Errorf("webhook span is not a child of the server span in the incoming trace")
+	}
+}
+
+// BenchmarkWebhookHandler measures the parsing and middleware overhead of a
+// webhook with STW_NO_EXPORT, where results go to no-op instruments.
+func BenchmarkWebhookHandler(b *testing.B) {
+	useSettings(b, map[string]string{"STW_NO_EXPORT": "true", "STW_HISTORY_SIZE": "0"})
+	useInstruments(b, nil)
+	h := withRequestID(http.HandlerFunc(webhookHandler))
+	for name, body := range benchmarkBodies {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			n := 0
+			for b.Loop() {
+				if rec := postWebhook(h, string(body)); rec.Code != http.StatusOK {
+					b.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+				}
+				n++
+			}
+			b.ReportMetric(float64(n)/b.Elapsed().Seconds(), "req/s")
+		})
+	}
+}
+
+// TestConcurrentWebhooks fires webhooks of a few servers at once, with the
+// per-server state and last-value gauges enabled, while metrics are collected
+// and the state is reset. Run it with -race.
+func TestConcurrentWebhooks(t *testing.T) {
+	useSettings(t, map[string]string{
+		"STW_HISTORY_SIZE":          "50",
+		"STW_GAUGE_WRITE_ORDER":     "timestamp",
+		"STW_PLAN_DOWNLOAD_MBPS":    "500",
+		"STW_QUALITY_SCORE":         "true",
+		"STW_BASELINE_WINDOW":       "5",
+		"STW_DOWNLOAD_RANGE_WINDOW": "1h",
+		"STW_ALLOW_RESET":           "true",
+	})
+	useInstruments(t, nil)
+	reader := sdkmetric.NewManualReader()
+	meter = sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("speedtest-webhook/meter")
+	if err := createInstruments(); err != nil {
+		t.Fatalf("createInstruments: %v", err)
+	}
+	prev := history
+	history = newResultHistory(settings.HistorySize)
+	t.Cleanup(func() { history = prev })
+	h := withRequestID(http.HandlerFunc(webhookHandler))
+
+	const workers, perWorker = 16, 25
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Go(func() {
+			for i := range perWorker {
+				id := w*perWorker + i
+				body := fmt.Sprintf(`{"result_id": %d, "serverId": %d, "serverName": "Server %d", "ping": %d, "download": %d, "upload": 50000000, "timestamp": %q}`,
+					id, id%4, id%4, 10+id%7, 200000000+id*1000, time.Now().Add(-time.Duration(id%5)*time.Minute).Format(time.RFC3339))
+				if rec := postWebhook(h, body); rec.Code != http.StatusOK {
+					t.Errorf("result %d: status = %d, body %q", id, rec.Code, rec.Body)
+				}
+			}
+		})
+	}
+	wg.Go(func() {
+		for range 20 {
+			var rm metricdata.ResourceMetrics
+			if err := reader.Collect(context.Background(), &rm); err != nil {
+				t.Errorf("collect: %v", err)
+			}
+		}
+	})
+	wg.Go(func() {
+		for range 5 {
+			rec := httptest.NewRecorder()
+			adminResetHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/reset", nil))
+			time.Sleep(time.Millisecond)
+		}
+	})
+	wg.Wait()
+	sinkSends.Wait()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// flexFloat is a float64 that unmarshals from either a JSON number or a JSON
+// string holding a number, since some senders quote numeric values.
+type flexFloat float64
+
+// nonFiniteError reports a quoted NaN or infinity, which strconv accepts but
+// no metric, span attribute or sink can carry.
+type nonFiniteError struct {
+	Value string
+}
+
+func (e *nonFiniteError) Error() string {
+	return fmt.Sprintf("non-finite numeric string %q", e.Value)
+}
+
+func (f *flexFloat) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid numeric string %q", s)
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return &nonFiniteError{Value: s}
+		}
+		*f = flexFloat(v)
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = flexFloat(v)
+	return nil
+}
+
+// roundValue rounds a recorded metric value to STW_VALUE_PRECISION decimals.
+// A negative precision keeps the value as is.
+func roundValue(v float64) float64 {
+	if settings.ValuePrecision < 0 {
+		return v
+	}
+	scale := math.Pow(10, float64(settings.ValuePrecision))
+	return math.Round(v*scale) / scale
+}
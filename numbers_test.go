@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDecodeNumbersAsStrings(t *testing.T) {
+	useSettings(t, nil)
+	decode := func(name string) WebhookPayload {
+		t.Helper()
+		body, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads, _, err := decodePayloads("application/json", body)
+		if err != nil {
+			t.Fatalf("decoding %s: %v", name, err)
+		}
+		return payloads[0]
+	}
+	numbers := decode("payload_numbers.json")
+	quoted := decode("payload_strings.json")
+	if !reflect.DeepEqual(numbers, quoted) {
+		t.Errorf("payloads differ:\nnumbers: %+v\nstrings: %+v", numbers, quoted)
+	}
+	if numbers.Download != 250000000 || *numbers.PacketLoss != 0.5 {
+		t.Errorf("unexpected values: download %v, packet loss %v", numbers.Download, *numbers.PacketLoss)
+	}
+}
+
+func TestFlexFloatRejectsNonFinite(t *testing.T) {
+	useSettings(t, nil)
+	for _, v := range []string{"NaN", "nan", "Inf", "-Inf", "+Infinity", "infinity"} {
+		t.Run(v, func(t *testing.T) {
+			body := []byte(`{"serverId": 1, "ping": 10, "download": "` + v + `", "upload": 1}`)
+			_, _, err := decodePayloads("application/json", body)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if status, _ := decodeErrorResponse(log.NewEntry(log.StandardLogger()), err); status != http.StatusUnprocessableEntity {
+				t.Errorf("status = %d, want %d", status, http.StatusUnprocessableEntity)
+			}
+		})
+	}
+}
+
+func TestFlexFloatRejectsInvalidString(t *testing.T) {
+	var f flexFloat
+	if err := f.UnmarshalJSON([]byte(`"fast"`)); err == nil {
+		t.Error("expected an error")
+	}
+}
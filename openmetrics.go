@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// metricsRegistry holds the registry of the Prometheus reader attached to the
+// active meter provider; nil until OpenTelemetry setup has succeeded.
+var metricsRegistry atomic.Pointer[prometheus.Registry]
+
+// openMetricsHandler writes a one-shot dump of the current metric state in
+// OpenMetrics text format.
+func openMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	reg := metricsRegistry.Load()
+	if reg == nil {
+		http.Error(w, "Metrics are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		log.Errorf("Could not gather metrics: %v", err)
+		http.Error(w, "Error gathering metrics", http.StatusInternalServerError)
+		return
+	}
+
+	format := expfmt.NewFormat(expfmt.TypeOpenMetrics)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			log.Errorf("Could not encode metrics: %v", err)
+			return
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Errorf("Could not finish metrics output: %v", err)
+		}
+	}
+}
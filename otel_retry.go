@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// otelRetrier keeps retrying OpenTelemetry setup in the background when
+// STW_OTEL_REQUIRED=false and the initial setup failed. Instruments created
+// from the global providers in the meantime start exporting once it succeeds.
+type otelRetrier struct {
+	mu       sync.Mutex
+	shutdown func(context.Context) error
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func startOTelRetrier(settings *Settings, interval time.Duration) *otelRetrier {
+	r := &otelRetrier{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for attempt := 1; ; attempt++ {
+			select {
+			case <-ticker.C:
+			case <-r.stop:
+				return
+			}
+			shutdown, err := setupOTelSDK(context.Background(), settings)
+			if err != nil {
+				log.Warnf("OpenTelemetry setup attempt %d failed, retrying in %s: %v", attempt, interval, err)
+				continue
+			}
+			r.mu.Lock()
+			r.shutdown = shutdown
+			r.mu.Unlock()
+			log.Infof("OpenTelemetry setup succeeded on retry %d", attempt)
+			return
+		}
+	}()
+	return r
+}
+
+// Shutdown stops retrying and shuts down the SDK if a retry brought it up.
+func (r *otelRetrier) Shutdown(ctx context.Context) error {
+	close(r.stop)
+	<-r.done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shutdown == nil {
+		return nil
+	}
+	return r.shutdown(ctx)
+}
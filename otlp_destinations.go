@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"golang.org/x/net/http/httpguts"
+)
+
+// otlpDestination is one OTLP backend that telemetry is exported to.
+//
+// The zero value exports according to the standard OTEL_EXPORTER_OTLP_* env vars,
+// which is what is used when STW_OTLP_DESTINATIONS is not set.
+type otlpDestination struct {
+	// Endpoint is the base URL; the signal path (/v1/traces, ...) is appended to it.
+	Endpoint string `json:"endpoint"`
+	// APIKey is sent as the api-key header, as expected by New Relic.
+	APIKey  string            `json:"apiKey"`
+	Headers map[string]string `json:"headers"`
+	// Insecure sends plaintext HTTP regardless of the endpoint scheme.
+	Insecure bool `json:"insecure"`
+	// CAFile is a PEM bundle used instead of the system roots to verify the endpoint.
+	CAFile string `json:"caFile"`
+	// InsecureSkipVerify disables verification of the endpoint certificate.
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+	// Compression is "gzip" or "none"; empty falls back to STW_OTLP_COMPRESSION.
+	Compression string `json:"compression"`
+}
+
+// parseOTLPDestinations decodes the JSON list held in STW_OTLP_DESTINATIONS.
+func parseOTLPDestinations(raw string) ([]otlpDestination, error) {
+	var dests []otlpDestination
+	if err := json.Unmarshal([]byte(raw), &dests); err != nil {
+		return nil, fmt.Errorf("invalid value for env var STW_OTLP_DESTINATIONS: %w", err)
+	}
+	for i, d := range dests {
+		u, err := url.Parse(d.Endpoint)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid value for env var STW_OTLP_DESTINATIONS: destination %d has invalid endpoint %q", i, d.Endpoint)
+		}
+		if err := validateHeaders(d.Headers); err != nil {
+			return nil, fmt.Errorf("invalid value for env var STW_OTLP_DESTINATIONS: destination %d: %w", i, err)
+		}
+		if !validOTLPCompression(d.Compression) {
+			return nil, fmt.Errorf("invalid value for env var STW_OTLP_DESTINATIONS: destination %d has invalid compression %q, expected gzip or none", i, d.Compression)
+		}
+	}
+	return dests, nil
+}
+
+// validOTLPCompression reports whether c is an accepted compression setting.
+func validOTLPCompression(c string) bool {
+	return c == "" || c == "gzip" || c == "none"
+}
+
+// compression resolves the destination compression against STW_OTLP_COMPRESSION.
+// It returns "" when neither is set, leaving OTEL_EXPORTER_OTLP_COMPRESSION in charge.
+func (d otlpDestination) compression() string {
+	if d.Compression != "" {
+		return d.Compression
+	}
+	return settings.OTLPCompression
+}
+
+// headers returns the static headers for the destination: STW_OTLP_HEADERS,
+// overridden by the destination's own headers and then the api-key. Because
+// the exporters replace the env headers with these, the env-configured
+// destination also merges in OTEL_EXPORTER_OTLP_HEADERS, below STW_OTLP_HEADERS.
+func (d otlpDestination) headers() map[string]string {
+	if d.APIKey == "" && len(d.Headers) == 0 && len(settings.OTLPHeaders) == 0 {
+		return nil
+	}
+	h := make(map[string]string)
+	if d.Endpoint == "" && len(settings.OTLPHeaders) > 0 {
+		for k, v := range envOTLPHeaders() {
+			h[k] = v
+		}
+	}
+	for k, v := range settings.OTLPHeaders {
+		h[k] = v
+	}
+	for k, v := range d.Headers {
+		h[k] = v
+	}
+	if d.APIKey != "" {
+		h["api-key"] = d.APIKey
+	}
+	return h
+}
+
+// envOTLPHeaders parses OTEL_EXPORTER_OTLP_HEADERS, whose values are URL-encoded.
+// Malformed entries are skipped, as the exporters do.
+func envOTLPHeaders() map[string]string {
+	h := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		k, v, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		v, err := url.PathUnescape(strings.TrimSpace(v))
+		if k == "" || err != nil {
+			continue
+		}
+		h[k] = v
+	}
+	return h
+}
+
+// validateHeaders checks that headers are valid HTTP header fields.
+func validateHeaders(headers map[string]string) error {
+	for k, v := range headers {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return fmt.Errorf("invalid header name %q", k)
+		}
+		if !httpguts.ValidHeaderFieldValue(v) {
+			return fmt.Errorf("invalid value for header %s", k)
+		}
+	}
+	return nil
+}
+
+// label names the destination in logs.
+func (d otlpDestination) label() string {
+	if d.Endpoint == "" {
+		return "OTEL_EXPORTER_OTLP_ENDPOINT"
+	}
+	return urlTarget(d.Endpoint)
+}
+
+// tlsConfig returns the client TLS configuration, or nil when the defaults apply.
+func (d otlpDestination) tlsConfig() (*tls.Config, error) {
+	if d.CAFile == "" && !d.InsecureSkipVerify {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: d.InsecureSkipVerify}
+	if d.CAFile != "" {
+		pem, err := os.ReadFile(d.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file for %s: %w", d.Endpoint, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", d.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// signalURL joins the destination endpoint with the OTLP path of a signal.
+func (d otlpDestination) signalURL(path string) string {
+	return strings.TrimSuffix(d.Endpoint, "/") + path
+}
+
+func (d otlpDestination) traceOptions() ([]otlptracehttp.Option, error) {
+	var opts []otlptracehttp.Option
+	if d.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(d.signalURL("/v1/traces")))
+	}
+	if d.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if h := d.headers(); h != nil {
+		opts = append(opts, otlptracehttp.WithHeaders(h))
+	}
+	switch d.compression() {
+	case "gzip":
+		opts = append(opts, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
+	case "none":
+		opts = append(opts, otlptracehttp.WithCompression(otlptracehttp.NoCompression))
+	}
+	tlsCfg, err := d.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.httpClient("traces", tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		opts = append(opts, otlptracehttp.WithHTTPClient(c))
+	} else if tlsCfg != nil {
+		opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsCfg))
+	}
+	return opts, nil
+}
+
+func (d otlpDestination) metricOptions() ([]otlpmetrichttp.Option, error) {
+	var opts []otlpmetrichttp.Option
+	if d.Endpoint != "" {
+		opts = append(opts, otlpmetrichttp.WithEndpointURL(d.signalURL("/v1/metrics")))
+	}
+	if d.Insecure {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	}
+	if h := d.headers(); h != nil {
+		opts = append(opts, otlpmetrichttp.WithHeaders(h))
+	}
+	switch d.compression() {
+	case "gzip":
+		opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+	case "none":
+		opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.NoCompression))
+	}
+	tlsCfg, err := d.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.httpClient("metrics", tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		opts = append(opts, otlpmetrichttp.WithHTTPClient(c))
+	} else if tlsCfg != nil {
+		opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsCfg))
+	}
+	return opts, nil
+}
+
+func (d otlpDestination) logOptions() ([]otlploghttp.Option, error) {
+	var opts []otlploghttp.Option
+	if d.Endpoint != "" {
+		opts = append(opts, otlploghttp.WithEndpointURL(d.signalURL("/v1/logs")))
+	}
+	if d.Insecure {
+		opts = append(opts, otlploghttp.WithInsecure())
+	}
+	if h := d.headers(); h != nil {
+		opts = append(opts, otlploghttp.WithHeaders(h))
+	}
+	switch d.compression() {
+	case "gzip":
+		opts = append(opts, otlploghttp.WithCompression(otlploghttp.GzipCompression))
+	case "none":
+		opts = append(opts, otlploghttp.WithCompression(otlploghttp.NoCompression))
+	}
+	tlsCfg, err := d.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.httpClient("logs", tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		opts = append(opts, otlploghttp.WithHTTPClient(c))
+	} else if tlsCfg != nil {
+		opts = append(opts, otlploghttp.WithTLSClientConfig(tlsCfg))
+	}
+	return opts, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpDefaultTimeout is the OTLP exporters' own default request timeout.
+const otlpDefaultTimeout = 10 * time.Second
+
+// otlpKeepaliveProbes is how many TCP keepalive probes may go unanswered
+// before a connection is dropped, so a single lost probe doesn't cut it.
+const otlpKeepaliveProbes = 3
+
+// httpClient returns the HTTP client of an exporter for signal ("traces",
+// "metrics" or "logs") when STW_OTLP_KEEPALIVE_TIME is set, and nil otherwise
+// so the exporter builds its own. The exporters speak OTLP over HTTP, so the
+// keepalive is a TCP probe, plus an HTTP/2 ping on connections that negotiated
+// HTTP/2: after STW_OTLP_KEEPALIVE_TIME of silence the connection is probed,
+// and dropped after otlpKeepaliveProbes TCP probes STW_OTLP_KEEPALIVE_TIMEOUT
+// apart, or an HTTP/2 ping, go unanswered. Each
+// call builds a new transport, so a recreated exporter gets fresh connections.
+// The exporters ignore their own TLS settings when given a client, so the
+// transport's TLS configuration is tlsCfg plus the OTEL_EXPORTER_OTLP_*
+// certificates, as otlpEnvTLSConfig reads them.
+func (d otlpDestination) httpClient(signal string, tlsCfg *tls.Config) (*http.Client, error) {
+	if settings.OTLPKeepaliveTime == 0 {
+		return nil, nil
+	}
+	tlsCfg, err := otlpEnvTLSConfig(signal, tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		KeepAliveConfig: net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     settings.OTLPKeepaliveTime,
+			Interval: settings.OTLPKeepaliveTimeout,
+			Count:    otlpKeepaliveProbes,
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSClientConfig = tlsCfg
+	transport.HTTP2 = &http.HTTP2Config{
+		SendPingTimeout: settings.OTLPKeepaliveTime,
+		PingTimeout:     settings.OTLPKeepaliveTimeout,
+	}
+	return &http.Client{Transport: transport, Timeout: otlpTimeout(signal)}, nil
+}
+
+// otlpEnvTLSConfig adds the CA and client certificate of
+// OTEL_EXPORTER_OTLP_<SIGNAL>_CERTIFICATE, _CLIENT_CERTIFICATE and _CLIENT_KEY,
+// or their OTEL_EXPORTER_OTLP_* fallbacks, to a copy of base. A CA already set
+// in base, from a destination's caFile, takes precedence.
+func otlpEnvTLSConfig(signal string, base *tls.Config) (*tls.Config, error) {
+	caFile := otlpSignalEnv(signal, "CERTIFICATE")
+	certFile := otlpSignalEnv(signal, "CLIENT_CERTIFICATE")
+	keyFile := otlpSignalEnv(signal, "CLIENT_KEY")
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return base, nil
+	}
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	if caFile != "" && cfg.RootCAs == nil {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading OTLP certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in OTLP certificate %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("OTLP client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading OTLP client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// otlpSignalEnv reads OTEL_EXPORTER_OTLP_<SIGNAL>_<name>, falling back to
+// OTEL_EXPORTER_OTLP_<name>.
+func otlpSignalEnv(signal, name string) string {
+	if v := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_" + strings.ToUpper(signal) + "_" + name)); v != "" {
+		return v
+	}
+	return strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_" + name))
+}
+
+// otlpTimeout reads OTEL_EXPORTER_OTLP_<SIGNAL>_TIMEOUT or
+// OTEL_EXPORTER_OTLP_TIMEOUT in milliseconds, which the exporters ignore when
+// given their HTTP client. Invalid values fall back to the default, as in the
+// exporters.
+func otlpTimeout(signal string) time.Duration {
+	for _, key := range []string{"OTEL_EXPORTER_OTLP_" + strings.ToUpper(signal) + "_TIMEOUT", "OTEL_EXPORTER_OTLP_TIMEOUT"} {
+		raw := strings.TrimSpace(os.Getenv(key))
+		if raw == "" {
+			continue
+		}
+		if ms, err := strconv.Atoi(raw); err == nil && ms >= 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+		return otlpDefaultTimeout
+	}
+	return otlpDefaultTimeout
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// exporterShutdown is the part every OTLP exporter has in common.
+type exporterShutdown interface {
+	Shutdown(ctx context.Context) error
+}
+
+// watchedExporter holds an OTLP exporter that is replaced by a fresh one after
+// STW_OTLP_MAX_FAILURES consecutive failed exports, at most once per
+// STW_OTLP_RECREATE_COOLDOWN. A new exporter gets a new HTTP client, which
+// clears connections stuck in a bad state. The providers keep their readers
+// and processors, so instruments keep working across a recreation.
+type watchedExporter[E exporterShutdown] struct {
+	// what names the exporter in logs, e.g. "metrics exporter for <endpoint>".
+	what   string
+	create func(ctx context.Context) (E, error)
+
+	mu           sync.RWMutex
+	current      E
+	failures     int
+	lastRecreate time.Time
+}
+
+func newWatchedExporter[E exporterShutdown](what string, current E, create func(ctx context.Context) (E, error)) *watchedExporter[E] {
+	return &watchedExporter[E]{what: what, create: create, current: current}
+}
+
+func (w *watchedExporter[E]) exporter() E {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// observe counts the outcome of an export and recreates the exporter once the
+// failures reach the threshold and the cooldown has passed.
+func (w *watchedExporter[E]) observe(ctx context.Context, err error) {
+	w.mu.Lock()
+	if err == nil {
+		w.failures = 0
+		w.mu.Unlock()
+		return
+	}
+	w.failures++
+	failures := w.failures
+	if failures < settings.OTLPMaxFailures || time.Since(w.lastRecreate) < settings.OTLPRecreateCooldown {
+		w.mu.Unlock()
+		return
+	}
+	w.lastRecreate = time.Now()
+	w.mu.Unlock()
+
+	fresh, createErr := w.create(context.WithoutCancel(ctx))
+	if createErr != nil {
+		log.Errorf("Could not recreate OTLP %s after %d consecutive failures: %v", w.what, failures, createErr)
+		return
+	}
+	w.mu.Lock()
+	old := w.current
+	w.current = fresh
+	w.failures = 0
+	w.mu.Unlock()
+	log.Warnf("Recreated OTLP %s after %d consecutive failed exports, last: %v", w.what, failures, err)
+
+	// The old exporter may be the stuck one, so don't wait on it.
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := old.Shutdown(ctx); err != nil {
+			log.Debugf("Shutting down replaced OTLP %s: %v", w.what, err)
+		}
+	}()
+}
+
+func (w *watchedExporter[E]) Shutdown(ctx context.Context) error {
+	return w.exporter().Shutdown(ctx)
+}
+
+// watchSpanExporter wraps e in the watchdog when STW_OTLP_MAX_FAILURES is set.
+func watchSpanExporter(d otlpDestination, e trace.SpanExporter) trace.SpanExporter {
+	if settings.OTLPMaxFailures == 0 {
+		return e
+	}
+	return watchedSpanExporter{newWatchedExporter("traces exporter for "+d.label(), e, func(ctx context.Context) (trace.SpanExporter, error) {
+		// Fresh options give the new exporter its own HTTP client under
+		// STW_OTLP_KEEPALIVE_TIME.
+		opts, err := d.traceOptions()
+		if err != nil {
+			return nil, err
+		}
+		return otlptracehttp.New(ctx, opts...)
+	})}
+}
+
+// watchMetricExporter wraps e in the watchdog when STW_OTLP_MAX_FAILURES is set.
+func watchMetricExporter(d otlpDestination, e metric.Exporter) metric.Exporter {
+	if settings.OTLPMaxFailures == 0 {
+		return e
+	}
+	return watchedMetricExporter{newWatchedExporter("metrics exporter for "+d.label(), e, func(ctx context.Context) (metric.Exporter, error) {
+		opts, err := d.metricOptions()
+		if err != nil {
+			return nil, err
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	})}
+}
+
+// watchLogExporter wraps e in the watchdog when STW_OTLP_MAX_FAILURES is set.
+func watchLogExporter(d otlpDestination, e sdklog.Exporter) sdklog.Exporter {
+	if settings.OTLPMaxFailures == 0 {
+		return e
+	}
+	return watchedLogExporter{newWatchedExporter("logs exporter for "+d.label(), e, func(ctx context.Context) (sdklog.Exporter, error) {
+		opts, err := d.logOptions()
+		if err != nil {
+			return nil, err
+		}
+		return otlploghttp.New(ctx, opts...)
+	})}
+}
+
+// watchedSpanExporter is a trace.SpanExporter backed by a watchedExporter.
+type watchedSpanExporter struct {
+	*watchedExporter[trace.SpanExporter]
+}
+
+func (w watchedSpanExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	err := w.exporter().ExportSpans(ctx, spans)
+	w.observe(ctx, err)
+	return err
+}
+
+// watchedMetricExporter is a metric.Exporter backed by a watchedExporter.
+type watchedMetricExporter struct {
+	*watchedExporter[metric.Exporter]
+}
+
+func (w watchedMetricExporter) Temporality(k metric.InstrumentKind) metricdata.Temporality {
+	return w.exporter().Temporality(k)
+}
+
+func (w watchedMetricExporter) Aggregation(k metric.InstrumentKind) metric.Aggregation {
+	return w.exporter().Aggregation(k)
+}
+
+func (w watchedMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	err := w.exporter().Export(ctx, rm)
+	w.observe(ctx, err)
+	return err
+}
+
+func (w watchedMetricExporter) ForceFlush(ctx context.Context) error {
+	return w.exporter().ForceFlush(ctx)
+}
+
+// watchedLogExporter is a sdklog.Exporter backed by a watchedExporter.
+type watchedLogExporter struct {
+	*watchedExporter[sdklog.Exporter]
+}
+
+func (w watchedLogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	err := w.exporter().Export(ctx, records)
+	w.observe(ctx, err)
+	return err
+}
+
+func (w watchedLogExporter) ForceFlush(ctx context.Context) error {
+	return w.exporter().ForceFlush(ctx)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Result outcomes reported on the speedtest.results counter.
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// failureHeuristics selects which checks mark a payload as a failed test.
+type failureHeuristics struct {
+	// Status treats an explicit successful=false or status=failed as a failure.
+	Status bool
+	// Zero treats a result with zero download and upload as a failure.
+	Zero bool
+}
+
+// parseFailureHeuristics parses STW_FAILURE_HEURISTIC, a comma list of "status" and "zero".
+// An empty value enables both; "none" disables failure detection.
+func parseFailureHeuristics(raw string) (failureHeuristics, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return failureHeuristics{Status: true, Zero: true}, nil
+	}
+	var h failureHeuristics
+	for _, name := range strings.Split(raw, ",") {
+		switch strings.TrimSpace(name) {
+		case "status":
+			h.Status = true
+		case "zero":
+			h.Zero = true
+		case "none":
+		default:
+			return h, fmt.Errorf("invalid value for env var STW_FAILURE_HEURISTIC %s: unknown heuristic %q", raw, name)
+		}
+	}
+	return h, nil
+}
+
+// outcome classifies a payload as a successful or failed test.
+func (h failureHeuristics) outcome(p WebhookPayload) string {
+	if h.Status {
+		if p.Successful != nil && !*p.Successful {
+			return outcomeFailure
+		}
+		if strings.EqualFold(p.Status, "failed") {
+			return outcomeFailure
+		}
+	}
+	if h.Zero && p.Download == 0 && p.Upload == 0 {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
@@ -0,0 +1,30 @@
+package main
+
+// planRatio is a result speed as a fraction of the advertised plan speed.
+type planRatio struct {
+	Direction string
+	Ratio     float64
+}
+
+// planRatios compares p with STW_PLAN_DOWNLOAD_MBPS and STW_PLAN_UPLOAD_MBPS.
+// meets is true when every configured speed is reached; ok is false when no
+// plan is configured.
+func planRatios(p WebhookPayload) (ratios []planRatio, meets, ok bool) {
+	meets = true
+	for _, d := range []struct {
+		direction string
+		bps       flexFloat
+		planMbps  float64
+	}{
+		{"download", p.Download, settings.PlanDownloadMbps},
+		{"upload", p.Upload, settings.PlanUploadMbps},
+	} {
+		if d.planMbps == 0 {
+			continue
+		}
+		r := float64(d.bps) / 1e6 / d.planMbps
+		ratios = append(ratios, planRatio{d.direction, r})
+		meets = meets && r >= 1
+	}
+	return ratios, meets, len(ratios) > 0
+}
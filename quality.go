@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Quality tiers, from best to worst.
+const (
+	qualityGood = "good"
+	qualityOK   = "ok"
+	qualityPoor = "poor"
+)
+
+// qualityThresholds are the limits a result must meet to reach a tier. Download
+// and upload are minimums in STW_SPEED_UNIT, ping (ms) and packet loss (%) are
+// maximums. Nil limits are not checked.
+type qualityThresholds struct {
+	Download, Upload, Ping, PacketLoss *float64
+}
+
+// parseQualityThresholds parses a "download=100,upload=20,ping=30,packet_loss=1"
+// list from key. It returns nil when the env var is unset.
+func parseQualityThresholds(key string) (*qualityThresholds, error) {
+	kv, err := envKeyValues(key)
+	if err != nil || kv == nil {
+		return nil, err
+	}
+	t := &qualityThresholds{}
+	for k, raw := range kv {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid value for env var %s: %s must be a non-negative number", key, k)
+		}
+		switch k {
+		case "download":
+			t.Download = &v
+		case "upload":
+			t.Upload = &v
+		case "ping":
+			t.Ping = &v
+		case "packet_loss":
+			t.PacketLoss = &v
+		default:
+			return nil, fmt.Errorf("invalid value for env var %s: unknown key %q, expected download, upload, ping or packet_loss", key, k)
+		}
+	}
+	return t, nil
+}
+
+// String formats t as parseQualityThresholds reads it, or "unset" when nil.
+func (t *qualityThresholds) String() string {
+	if t == nil {
+		return "unset"
+	}
+	var parts []string
+	for _, l := range []struct {
+		key   string
+		value *float64
+	}{{"download", t.Download}, {"upload", t.Upload}, {"ping", t.Ping}, {"packet_loss", t.PacketLoss}} {
+		if l.value != nil {
+			parts = append(parts, l.key+"="+strconv.FormatFloat(*l.value, 'g', -1, 64))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// meets reports whether p satisfies every limit in t.
+func (t *qualityThresholds) meets(p WebhookPayload) bool {
+	return t != nil && len(t.breaches(p)) == 0
+}
+
+// breaches describes every limit in t that p fails.
+func (t *qualityThresholds) breaches(p WebhookPayload) []string {
+	if t == nil {
+		return nil
+	}
+	var out []string
+	unit := settings.SpeedUnit.OtelUnit
+	if down := float64(p.Download) / settings.SpeedUnit.Divisor; t.Download != nil && down < *t.Download {
+		out = append(out, fmt.Sprintf("download %g %s below %g", down, unit, *t.Download))
+	}
+	if up := float64(p.Upload) / settings.SpeedUnit.Divisor; t.Upload != nil && up < *t.Upload {
+		out = append(out, fmt.Sprintf("upload %g %s below %g", up, unit, *t.Upload))
+	}
+	if t.Ping != nil && float64(p.Ping) > *t.Ping {
+		out = append(out, fmt.Sprintf("ping %g ms above %g", float64(p.Ping), *t.Ping))
+	}
+	if t.PacketLoss != nil && p.PacketLoss != nil && float64(*p.PacketLoss) > *t.PacketLoss {
+		out = append(out, fmt.Sprintf("packet loss %g%% above %g", float64(*p.PacketLoss), *t.PacketLoss))
+	}
+	return out
+}
+
+// qualityTier classifies a successful result. It returns false when no tier
+// thresholds are configured.
+func qualityTier(p WebhookPayload) (string, bool) {
+	th := thresholds()
+	switch {
+	case th.QualityGood == nil && th.QualityOK == nil:
+		return "", false
+	case th.QualityGood.meets(p):
+		return qualityGood, true
+	case th.QualityOK.meets(p):
+		return qualityOK, true
+	default:
+		return qualityPoor, true
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// qualityScoreFactors holds one value per component of the quality score.
+type qualityScoreFactors struct {
+	Download, Upload, Ping, Jitter, PacketLoss float64
+}
+
+// defaultQualityScoreWeights favour the speeds, which matter most to users.
+var defaultQualityScoreWeights = qualityScoreFactors{Download: 30, Upload: 20, Ping: 20, Jitter: 15, PacketLoss: 15}
+
+// parseQualityScoreFactors parses a "download=30,upload=20,ping=20,jitter=15,packet_loss=15"
+// list from key over def.
+func parseQualityScoreFactors(key string, def qualityScoreFactors) (qualityScoreFactors, error) {
+	kv, err := envKeyValues(key)
+	if err != nil {
+		return def, err
+	}
+	f := def
+	for k, raw := range kv {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) {
+			return def, fmt.Errorf("invalid value for env var %s: %s must be a non-negative number", key, k)
+		}
+		switch k {
+		case "download":
+			f.Download = v
+		case "upload":
+			f.Upload = v
+		case "ping":
+			f.Ping = v
+		case "jitter":
+			f.Jitter = v
+		case "packet_loss":
+			f.PacketLoss = v
+		default:
+			return def, fmt.Errorf("invalid value for env var %s: unknown key %q, expected download, upload, ping, jitter or packet_loss", key, k)
+		}
+	}
+	return f, nil
+}
+
+// qualityScoreOf scores a successful result from 0 to 100. Every component is
+// normalized to 0..1 against STW_QUALITY_SCORE_TARGETS:
+//
+//	download, upload: Mbit/s / target, capped at 1
+//	ping, jitter:     1 up to the target (ms), then target / value
+//	packet_loss:      1 - loss / target (%), floored at 0
+//
+// The score is 100 times the STW_QUALITY_SCORE_WEIGHTS-weighted mean of the
+// components, rounded to one decimal. Jitter and packet loss count only when the payload has them;
+// ok is false when no component has weight.
+func qualityScoreOf(p WebhookPayload) (score float64, ok bool) {
+	w, t := settings.QualityScoreWeights, settings.QualityScoreTargets
+	var sum, weights float64
+	add := func(weight, value float64) {
+		sum += weight * value
+		weights += weight
+	}
+	add(w.Download, min(float64(p.Download)/1e6/t.Download, 1))
+	add(w.Upload, min(float64(p.Upload)/1e6/t.Upload, 1))
+	add(w.Ping, latencyScore(float64(p.Ping), t.Ping))
+	if p.Jitter != nil {
+		add(w.Jitter, latencyScore(float64(*p.Jitter), t.Jitter))
+	}
+	if p.PacketLoss != nil {
+		add(w.PacketLoss, max(1-float64(*p.PacketLoss)/t.PacketLoss, 0))
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return math.Round(1000*sum/weights) / 10, true
+}
+
+// latencyScore is 1 for values up to target and falls off as target / v beyond.
+func latencyScore(v, target float64) float64 {
+	if v <= target {
+		return 1
+	}
+	return target / v
+}
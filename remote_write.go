@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// remoteWriteMaxPending bounds the samples buffered between pushes.
+	remoteWriteMaxPending = 10000
+	// remoteWriteMaxAttempts is how many times a batch is sent before it is dropped.
+	remoteWriteMaxAttempts = 5
+	remoteWriteMaxBackoff  = 30 * time.Second
+	// remoteWriteMaxSplitPushes bounds the pushes of the halves of a rejected
+	// batch, in case the receiver rejects every sample.
+	remoteWriteMaxSplitPushes = 100
+)
+
+// remoteWriteConfig configures the Prometheus remote-write sink.
+type remoteWriteConfig struct {
+	URL         string
+	Username    string
+	Password    string
+	BearerToken string
+	Interval    time.Duration
+}
+
+type rwLabel struct {
+	Name, Value string
+}
+
+type rwSample struct {
+	Value       float64
+	TimestampMs int64
+}
+
+type rwSeries struct {
+	Labels  []rwLabel
+	Samples []rwSample
+}
+
+// remoteWriteSink batches result samples and pushes them to a Prometheus
+// remote-write endpoint (Mimir, Thanos, VictoriaMetrics, ...) on an interval.
+type remoteWriteSink struct {
+	cfg    remoteWriteConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []rwSeries
+	// pendingIDs are the request IDs of the results in pending, for log correlation.
+	pendingIDs []string
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newRemoteWriteSink(cfg remoteWriteConfig) *remoteWriteSink {
+	s := &remoteWriteSink{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *remoteWriteSink) Name() string   { return "remote-write" }
+func (s *remoteWriteSink) Kind() string   { return "remote-write" }
+func (s *remoteWriteSink) Target() string { return urlTarget(s.cfg.URL) }
+
+// Send buffers the ping, download and upload samples of a successful result.
+func (s *remoteWriteSink) Send(ctx context.Context, res storedResult) error {
+	if res.Outcome == outcomeFailure {
+		return nil
+	}
+	p := res.Payload
+	labels := []rwLabel{
+		{"isp", p.ISP},
+		{"server_id", strconv.Itoa(p.ServerID)},
+		{"server_name", p.ServerName},
+	}
+	if res.Tenant != "" {
+		labels = append(labels, rwLabel{"tenant", res.Tenant})
+	}
+	for _, a := range settings.StaticMetricAttributes {
+		labels = append(labels, rwLabel{sanitizeLabelName(string(a.Key)), a.Value.AsString()})
+	}
+	ts := res.ReceivedAt.UnixMilli()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending)+3 > remoteWriteMaxPending {
+		return fmt.Errorf("remote-write buffer full, dropping result")
+	}
+	for _, m := range []struct {
+		name  string
+		value float64
+	}{
+		{"speedtest_ping", roundValue(float64(p.Ping))},
+		{"speedtest_download", roundValue(float64(p.Download) / settings.SpeedUnit.Divisor)},
+		{"speedtest_upload", roundValue(float64(p.Upload) / settings.SpeedUnit.Divisor)},
+	} {
+		series := rwSeries{
+			Labels:  append([]rwLabel{{"__name__", m.name}}, labels...),
+			Samples: []rwSample{{Value: m.value, TimestampMs: ts}},
+		}
+		sort.Slice(series.Labels, func(i, j int) bool { return series.Labels[i].Name < series.Labels[j].Name })
+		s.pending = append(s.pending, series)
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		s.pendingIDs = append(s.pendingIDs, id)
+	}
+	return nil
+}
+
+// Close stops the push loop and flushes the remaining samples.
+func (s *remoteWriteSink) Close(ctx context.Context) error {
+	close(s.done)
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.flush(ctx)
+}
+
+func (s *remoteWriteSink) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// flush logs its own outcome.
+			_ = s.flush(context.Background())
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// flush pushes the pending samples, retrying with backoff on retryable errors.
+// A batch the receiver rejects as a whole, such as for out-of-order samples, is
+// split in halves that are pushed on their own, down to the rejected samples,
+// which are logged and dropped, for up to remoteWriteMaxSplitPushes pushes.
+// Other batches are dropped once the attempts
+// are exhausted. Every attempt and the final outcome are logged with the
+// request IDs of the batched results.
+func (s *remoteWriteSink) flush(ctx context.Context) error {
+	s.mu.Lock()
+	batch, ids := s.pending, s.pendingIDs
+	s.pending, s.pendingIDs = nil, nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	logger := log.WithFields(log.Fields{
+		"sink":        s.Name(),
+		"samples":     len(batch),
+		"request_ids": ids,
+	})
+	splits := remoteWriteMaxSplitPushes
+	dropped, err := s.pushSplitting(ctx, logger, batch, &splits)
+	if err != nil {
+		logger.WithFields(log.Fields{"dropped": dropped, "outcome": "dropped"}).WithError(err).Error("Remote write push failed")
+		return fmt.Errorf("dropped %d of %d samples: %w", dropped, len(batch), err)
+	}
+	return nil
+}
+
+// pushSplitting pushes batch, splitting it when the receiver rejects it while
+// splits remain, and returns how many samples were dropped.
+func (s *remoteWriteSink) pushSplitting(ctx context.Context, logger *log.Entry, batch []rwSeries, splits *int) (dropped int, err error) {
+	err = s.push(ctx, logger, batch)
+	var rejected *rejectedBatchError
+	if err == nil {
+		return 0, nil
+	}
+	if !errors.As(err, &rejected) || ctx.Err() != nil || (len(batch) > 1 && *splits < 2) {
+		return len(batch), err
+	}
+	if len(batch) == 1 {
+		logger.WithFields(log.Fields{
+			"series":       seriesName(batch[0]),
+			"timestamp_ms": batch[0].Samples[0].TimestampMs,
+			"value":        batch[0].Samples[0].Value,
+		}).WithError(err).Warn("Remote write receiver rejected a sample, dropping it")
+		return 1, err
+	}
+	logger.WithField("batch", len(batch)).WithError(err).Debug("Remote write receiver rejected the batch, splitting it")
+	*splits -= 2
+	mid := len(batch) / 2
+	d1, err1 := s.pushSplitting(ctx, logger, batch[:mid], splits)
+	d2, err2 := s.pushSplitting(ctx, logger, batch[mid:], splits)
+	// The rejections of single samples are logged above; report one of them.
+	return d1 + d2, cmp.Or(err1, err2)
+}
+
+// push sends batch, retrying retryable errors with backoff.
+func (s *remoteWriteSink) push(ctx context.Context, logger *log.Entry, batch []rwSeries) error {
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := s.post(ctx, body)
+		if err == nil {
+			entry := logger.WithFields(log.Fields{"attempts": attempt, "outcome": "delivered", "batch": len(batch)})
+			if attempt > 1 {
+				entry.Info("Remote write push succeeded after retries")
+			} else {
+				entry.Debug("Remote write push succeeded")
+			}
+			return nil
+		}
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || attempt == remoteWriteMaxAttempts {
+			return fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+		logger.WithFields(log.Fields{"attempt": attempt, "backoff": backoff.String()}).WithError(err).Warn("Remote write attempt failed, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("canceled after %d attempts: %w", attempt, ctx.Err())
+		}
+		backoff = min(backoff*2, remoteWriteMaxBackoff)
+	}
+}
+
+// seriesName formats the labels of series as Prometheus does, for logs.
+func seriesName(series rwSeries) string {
+	var name string
+	var labels []string
+	for _, l := range series.Labels {
+		if l.Name == "__name__" {
+			name = l.Value
+			continue
+		}
+		labels = append(labels, l.Name+"="+strconv.Quote(l.Value))
+	}
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
+
+func (s *remoteWriteSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "speedtest-tracker-webhook")
+	if s.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.BearerToken)
+	} else if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &retryableError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote write returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return &retryableError{err}
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestEntityTooLarge:
+		// Some samples, or the size, were rejected; a smaller batch may pass.
+		return &rejectedBatchError{err}
+	}
+	// Other 4xx responses, such as auth failures, fail any batch alike.
+	return err
+}
+
+// retryableError marks a failed push that may succeed when retried.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// rejectedBatchError marks a push the receiver refused for its content, which
+// smaller batches with fewer samples may avoid.
+type rejectedBatchError struct {
+	err error
+}
+
+func (e *rejectedBatchError) Error() string { return e.err.Error() }
+func (e *rejectedBatchError) Unwrap() error { return e.err }
+
+// encodeWriteRequest serializes series as a prometheus.WriteRequest protobuf message,
+// merging series that share a label set.
+func encodeWriteRequest(batch []rwSeries) []byte {
+	var order []string
+	merged := make(map[string]*rwSeries)
+	for _, series := range batch {
+		var key strings.Builder
+		for _, l := range series.Labels {
+			key.WriteString(l.Name + "\xff" + l.Value + "\xff")
+		}
+		k := key.String()
+		if m, ok := merged[k]; ok {
+			m.Samples = append(m.Samples, series.Samples...)
+			continue
+		}
+		merged[k] = &rwSeries{Labels: series.Labels, Samples: append([]rwSample(nil), series.Samples...)}
+		order = append(order, k)
+	}
+
+	var req []byte
+	for _, k := range order {
+		series := merged[k]
+		sort.Slice(series.Samples, func(i, j int) bool { return series.Samples[i].TimestampMs < series.Samples[j].TimestampMs })
+
+		var ts []byte
+		for _, l := range series.Labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		for _, smp := range series.Samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(smp.Value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(smp.TimestampMs))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sb)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// sanitizeLabelName replaces characters that are not valid in Prometheus label names.
+func sanitizeLabelName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+func TestRemoteWriteSeriesPerTenant(t *testing.T) {
+	useSettings(t, nil)
+	s := &remoteWriteSink{}
+	p := WebhookPayload{ServerID: 7, ServerName: "Example", ISP: "ISP", Ping: 10, Download: 1e8, Upload: 1e7}
+	for _, tenant := range []string{"", "home", "office"} {
+		if err := s.Send(context.Background(), storedResult{ReceivedAt: time.Now(), Outcome: outcomeSuccess, Tenant: tenant, Payload: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, series := range s.pending {
+		key, tenant := "", ""
+		for _, l := range series.Labels {
+			key += l.Name + "=" + l.Value + ","
+			if l.Name == "tenant" {
+				tenant = l.Value
+			}
+		}
+		if seen[key] {
+			t.Errorf("duplicate series %s", key)
+		}
+		seen[key] = true
+		if tenant == "" && len(series.Labels) != 4 {
+			t.Errorf("series without a tenant has labels %v", series.Labels)
+		}
+	}
+	if len(seen) != 9 {
+		t.Errorf("got %d distinct series, want 9", len(seen))
+	}
+}
+
+func TestBaselinesPerTenant(t *testing.T) {
+	useSettings(t, map[string]string{"STW_BASELINE_WINDOW": "2"})
+	useInstruments(t, nil)
+	b := &baselines{servers: make(map[lastSeenKey]map[string][]float64)}
+	ctx := context.Background()
+	b.observe(ctx, "home", WebhookPayload{ServerID: 7, Download: 1e8}, nil)
+	b.observe(ctx, "office", WebhookPayload{ServerID: 7, Download: 5e8}, nil)
+	if len(b.servers) != 2 {
+		t.Fatalf("got %d baselines, want one per tenant", len(b.servers))
+	}
+	if got := b.servers[lastSeenKey{"office", 7}]["download"]; len(got) != 1 || got[0] != 5e8/settings.SpeedUnit.Divisor {
+		t.Errorf("office download window = %v", got)
+	}
+}
+
+func TestRemoteWriteDropsOnlyRejectedSamples(t *testing.T) {
+	useSettings(t, nil)
+	var accepted atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Stand in for out-of-order samples, e.g. replayed ones.
+		if bytes.Contains(body, []byte("replayed")) {
+			http.Error(w, "out of order sample", http.StatusBadRequest)
+			return
+		}
+		accepted.Add(int32(bytes.Count(body, []byte("fresh"))))
+	}))
+	defer receiver.Close()
+	s := &remoteWriteSink{cfg: remoteWriteConfig{URL: receiver.URL}, client: receiver.Client()}
+
+	now := time.Now()
+	for i, name := range []string{"fresh-1", "replayed", "fresh-2", "fresh-3"} {
+		p := WebhookPayload{ServerID: i, ServerName: name, Ping: 10, Download: 1e8, Upload: 1e7}
+		if err := s.Send(context.Background(), storedResult{ReceivedAt: now, Outcome: outcomeSuccess, Payload: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := s.flush(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "dropped 3 of 12 samples") {
+		t.Errorf("flush error = %v, want the 3 replayed samples dropped", err)
+	}
+	if got := accepted.Load(); got != 9 {
+		t.Errorf("receiver accepted %d fresh samples, want 9", got)
+	}
+}
+
+func TestRemoteWriteBoundsSplitPushes(t *testing.T) {
+	useSettings(t, nil)
+	var pushes atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes.Add(1)
+		http.Error(w, "bad", http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+	s := &remoteWriteSink{cfg: remoteWriteConfig{URL: receiver.URL}, client: receiver.Client()}
+	for i := range 1000 {
+		p := WebhookPayload{ServerID: i, ServerName: fmt.Sprint(i)}
+		if err := s.Send(context.Background(), storedResult{ReceivedAt: time.Now(), Outcome: outcomeSuccess, Payload: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.flush(context.Background()); err == nil || !strings.Contains(err.Error(), "dropped 3000 of 3000") {
+		t.Errorf("flush error = %v", err)
+	}
+	if n := pushes.Load(); n > remoteWriteMaxSplitPushes+1 {
+		t.Errorf("%d pushes, want at most %d", n, remoteWriteMaxSplitPushes+1)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds incoming request IDs so they can't flood logs.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID honors a valid incoming X-Request-ID or generates a new one,
+// stores it in the request context and echoes it in the response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestIDFrom returns the request ID stored in ctx, or "" when there is none.
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logFrom returns a logger carrying the request ID of ctx, if any.
+func logFrom(ctx context.Context) *log.Entry {
+	if id := requestIDFrom(ctx); id != "" {
+		return log.WithField("request_id", id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts non-empty IDs of printable ASCII up to maxRequestIDLength.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
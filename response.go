@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// writeJSON encodes v as the JSON response body with the given status code,
+// indented when STW_PRETTY_JSON is set.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	if settings.PrettyJSON {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		log.Errorf("Could not write JSON response: %v", err)
+	}
+}
+
+// errorResponse is the JSON body returned for request errors.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeMethodNotAllowed answers a request with an unsupported method, advertising
+// the allowed ones in the Allow header.
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "Method not allowed"})
+}
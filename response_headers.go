@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultResponseHeaders are sent on every response unless STW_RESPONSE_HEADERS
+// overrides or clears them.
+var defaultResponseHeaders = map[string]string{
+	"X-Content-Type-Options": "nosniff",
+	"X-Frame-Options":        "DENY",
+	"Referrer-Policy":        "no-referrer",
+}
+
+// responseHeaders merges STW_RESPONSE_HEADERS into the defaults. An entry with
+// an empty value removes that header.
+func responseHeaders(custom map[string]string) map[string]string {
+	headers := make(map[string]string, len(defaultResponseHeaders)+len(custom))
+	for k, v := range defaultResponseHeaders {
+		headers[k] = v
+	}
+	for k, v := range custom {
+		k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+		if v == "" {
+			delete(headers, k)
+			continue
+		}
+		headers[k] = v
+	}
+	return headers
+}
+
+// withResponseHeaders sets the configured response headers before next runs,
+// so handlers can still override them.
+func withResponseHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		for k, v := range settings.ResponseHeaders {
+			h.Set(k, v)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
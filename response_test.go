@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMethodNotAllowedAdvertisesAllow(t *testing.T) {
+	useSettings(t, nil)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		allow   string
+	}{
+		{"config", configHandler, http.MethodPost, "GET"},
+		{"results", resultsHandler, http.MethodDelete, "GET"},
+		{"results.csv", resultsCSVHandler, http.MethodPost, "GET"},
+		{"openmetrics", openMetricsHandler, http.MethodPost, "GET"},
+		{"run-test", runTestHandler, http.MethodGet, "POST"},
+		{"admin/sinks", adminSinksHandler, http.MethodDelete, "GET, POST"},
+		{"status", statusHandler, http.MethodPost, "GET"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(tt.method, "/", nil))
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want a JSON error body", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// statusRecorder captures the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	// Informational responses aren't final; keep waiting for the real status.
+	if r.status == 0 && (code < 100 || code >= 200) {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// withResponseCounter counts every response by its final status code in
+// speedtest.http.responses.
+func withResponseCounter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		status := rec.status
+		if status == 0 {
+			// Nothing was written, which net/http sends as an empty 200.
+			status = http.StatusOK
+		}
+		httpResponsesCounter.Add(r.Context(), 1, metric.WithAttributes(attribute.String("status_code", strconv.Itoa(status))))
+	})
+}
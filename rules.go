@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"gopkg.in/yaml.v3"
+)
+
+// logChannel is the built-in channel that logs alerts; it is used by rules
+// that name no channels.
+const logChannel = "log"
+
+// alertMetrics are the result values rules can test. Speeds are in STW_SPEED_UNIT.
+var alertMetrics = map[string]func(p WebhookPayload) (float64, bool){
+	"ping":     func(p WebhookPayload) (float64, bool) { return float64(p.Ping), true },
+	"download": func(p WebhookPayload) (float64, bool) { return float64(p.Download) / settings.SpeedUnit.Divisor, true },
+	"upload":   func(p WebhookPayload) (float64, bool) { return float64(p.Upload) / settings.SpeedUnit.Divisor, true },
+	"packet_loss": func(p WebhookPayload) (float64, bool) {
+		if p.PacketLoss == nil {
+			return 0, false
+		}
+		return float64(*p.PacketLoss), true
+	},
+}
+
+var alertOperators = map[string]func(v, threshold float64) bool{
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+var alertSeverities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// alertRule fires when Metric compared with Operator to Value is true.
+type alertRule struct {
+	Name     string   `yaml:"name"`
+	Metric   string   `yaml:"metric"`
+	Operator string   `yaml:"operator"`
+	Value    float64  `yaml:"value"`
+	Severity string   `yaml:"severity"`
+	Channels []string `yaml:"channels"`
+}
+
+// alertChannel is a destination alerts are sent to. Only webhook channels are
+// configured in the file; the log channel is built in.
+type alertChannel struct {
+	Type    string            `yaml:"type"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// rulesFile is the layout of STW_RULES_FILE.
+type rulesFile struct {
+	Channels map[string]alertChannel `yaml:"channels"`
+	Rules    []alertRule             `yaml:"rules"`
+}
+
+// alert is a fired or resolved rule, as logged and posted to webhook channels.
+type alert struct {
+	Rule string `json:"rule"`
+	// Status is firing, or resolved for the first result of the server that no
+	// longer breaches the rule.
+	Status     string  `json:"status"`
+	Severity   string  `json:"severity"`
+	Metric     string  `json:"metric"`
+	Operator   string  `json:"operator"`
+	Threshold  float64 `json:"threshold"`
+	Value      float64 `json:"value"`
+	ResultID   int     `json:"result_id"`
+	SiteName   string  `json:"site_name"`
+	ServerID   int     `json:"server_id"`
+	ServerName string  `json:"server_name"`
+	// Environment is STW_ENVIRONMENT and ConnectionType the result's
+	// connection type, when set.
+	Environment    string `json:"environment,omitempty"`
+	ConnectionType string `json:"connection_type,omitempty"`
+	RequestID      string `json:"request_id,omitempty"`
+	// FiredAt is when the breach started; a reminder after STW_ALERT_COOLDOWN
+	// keeps the time of the first notification.
+	FiredAt    time.Time  `json:"fired_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// activeRules holds the rules file currently in effect, or nil.
+var activeRules atomic.Pointer[rulesFile]
+
+// alertClient posts alerts to webhook channels.
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// loadRulesFile reads and validates a rules file. Unknown keys are rejected so
+// typos don't silently disable a rule.
+func loadRulesFile(path string) (*rulesFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rules file: %w", err)
+	}
+	var rf rulesFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rf); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parsing rules file %s: %w", path, err)
+	}
+
+	for name, ch := range rf.Channels {
+		if name == logChannel {
+			return nil, fmt.Errorf("rules file %s: channel name %q is reserved", path, logChannel)
+		}
+		if ch.Type != "webhook" {
+			return nil, fmt.Errorf("rules file %s: channel %q has unsupported type %q, expected webhook", path, name, ch.Type)
+		}
+		if u, err := url.Parse(ch.URL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("rules file %s: channel %q has invalid url %q", path, name, ch.URL)
+		}
+	}
+	for i, r := range rf.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rules file %s: rule %d has no name", path, i)
+		}
+		if _, ok := alertMetrics[r.Metric]; !ok {
+			return nil, fmt.Errorf("rules file %s: rule %q has unknown metric %q, expected ping, download, upload or packet_loss", path, r.Name, r.Metric)
+		}
+		if _, ok := alertOperators[r.Operator]; !ok {
+			return nil, fmt.Errorf("rules file %s: rule %q has unknown operator %q", path, r.Name, r.Operator)
+		}
+		if r.Severity == "" {
+			rf.Rules[i].Severity = "warning"
+		} else if !alertSeverities[r.Severity] {
+			return nil, fmt.Errorf("rules file %s: rule %q has unknown severity %q, expected info, warning or critical", path, r.Name, r.Severity)
+		}
+		for _, ch := range r.Channels {
+			if _, ok := rf.Channels[ch]; !ok && ch != logChannel {
+				return nil, fmt.Errorf("rules file %s: rule %q uses undefined channel %q", path, r.Name, ch)
+			}
+		}
+	}
+	return &rf, nil
+}
+
+// reloadRules re-reads STW_RULES_FILE, keeping the current rules if the new
+// file is invalid.
+func reloadRules() {
+	rf, err := loadRulesFile(settings.RulesFile)
+	if err != nil {
+		log.Errorf("Keeping current alert rules: %v", err)
+		return
+	}
+	activeRules.Store(rf)
+	log.Infof("Reloaded %d alert rules from %s", len(rf.Rules), settings.RulesFile)
+}
+
+// evaluateRules checks a successful result against the active rules and
+// dispatches every alert that fires, unless its server breached the rule
+// within STW_ALERT_COOLDOWN, and a recovery for every rule that stopped
+// firing.
+func evaluateRules(ctx context.Context, tenant string, p WebhookPayload, connectionType string) {
+	rf := activeRules.Load()
+	if rf == nil {
+		return
+	}
+	now := time.Now()
+	for _, r := range rf.Rules {
+		v, ok := alertMetrics[r.Metric](p)
+		if !ok {
+			continue
+		}
+		ruleAttrs := metric.WithAttributes(
+			attribute.String("rule", r.Name),
+			attribute.String("severity", r.Severity),
+		)
+		status, firedAt := alertFiring, now
+		var resolvedAt *time.Time
+		if alertOperators[r.Operator](v, r.Value) {
+			notify, since := ruleAlertStates.breached(r.Name, tenant, p, now)
+			if !notify {
+				logFrom(ctx).Debugf("Suppressing alert %s for server ID %d within STW_ALERT_COOLDOWN", r.Name, p.ServerID)
+				alertsSuppressedCounter.Add(ctx, 1, ruleAttrs)
+				continue
+			}
+			firedAt = since
+			alertsCounter.Add(ctx, 1, ruleAttrs)
+		} else {
+			recovered, since := ruleAlertStates.cleared(r.Name, tenant, p)
+			if !recovered {
+				continue
+			}
+			status, firedAt, resolvedAt = alertResolved, since, &now
+		}
+		a := alert{
+			Rule:           r.Name,
+			Status:         status,
+			Severity:       r.Severity,
+			Metric:         r.Metric,
+			Operator:       r.Operator,
+			Threshold:      r.Value,
+			Value:          v,
+			ResultID:       p.ResultID,
+			SiteName:       p.SiteName,
+			ServerID:       p.ServerID,
+			ServerName:     p.ServerName,
+			Environment:    settings.Environment,
+			ConnectionType: connectionType,
+			RequestID:      requestIDFrom(ctx),
+			FiredAt:        firedAt,
+			ResolvedAt:     resolvedAt,
+		}
+		channels := r.Channels
+		if len(channels) == 0 {
+			channels = []string{logChannel}
+		}
+		for _, name := range channels {
+			dispatchAlert(ctx, name, rf.Channels[name], a)
+		}
+	}
+}
+
+// dispatchAlert delivers an alert to one channel. Webhook deliveries run in the
+// background so a slow receiver doesn't hold up the response.
+func dispatchAlert(ctx context.Context, name string, ch alertChannel, a alert) {
+	logger := logFrom(ctx)
+	if name == logChannel {
+		logger := logger.WithFields(log.Fields{
+			"rule":      a.Rule,
+			"severity":  a.Severity,
+			"value":     a.Value,
+			"threshold": a.Threshold,
+		})
+		if a.Status == alertResolved {
+			logger.Infof("Alert %s resolved: %s %g no longer %s %g", a.Rule, a.Metric, a.Value, a.Operator, a.Threshold)
+			return
+		}
+		logger.Warnf("Alert %s: %s %g %s %g", a.Rule, a.Metric, a.Value, a.Operator, a.Threshold)
+		return
+	}
+
+	body, err := json.Marshal(a)
+	if err != nil {
+		logger.Errorf("Could not encode alert %s: %v", a.Rule, err)
+		return
+	}
+	startAlertDelivery(ctx, logger, name, ch, a, body)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// serverNameAcronymLen is the longest word of an all-caps name that
+// title-casing keeps as is, so "NEW YORK NY" becomes "New York NY".
+const serverNameAcronymLen = 2
+
+// normalizeServerName applies STW_SERVER_NAME_ALIASES and
+// STW_SERVER_NAME_NORMALIZE to a payload's server name. Whitespace is trimmed
+// and collapsed first; an alias, matched case-insensitively, replaces the
+// name verbatim, and other names are title-cased when normalizing is on.
+func normalizeServerName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	if alias, ok := settings.ServerNameAliases[strings.ToLower(name)]; ok {
+		return alias
+	}
+	if !settings.ServerNameNormalize {
+		return name
+	}
+	allCaps := name == strings.ToUpper(name)
+	words := strings.Split(name, " ")
+	for i, w := range words {
+		words[i] = titleWord(w, allCaps)
+	}
+	return strings.Join(words, " ")
+}
+
+// titleWord capitalizes a lower-case word. Mixed-case words such as "McAllen"
+// are left alone, and so are all-caps words, taken as acronyms ("AT&T"),
+// unless the whole name is in caps and the word is longer than an acronym.
+func titleWord(w string, allCaps bool) string {
+	lower, upper := strings.ToLower(w), strings.ToUpper(w)
+	if w == upper && (!allCaps || utf8.RuneCountInString(w) <= serverNameAcronymLen) {
+		return w
+	}
+	if w != lower && w != upper {
+		return w
+	}
+	first, size := utf8.DecodeRuneInString(lower)
+	return string(unicode.ToUpper(first)) + lower[size:]
+}
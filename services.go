@@ -0,0 +1,36 @@
+package main
+
+import "strings"
+
+// defaultService is assumed for results without a service field, which
+// Speedtest Tracker only started sending once it supported other engines.
+const defaultService = "ookla"
+
+// parseAllowedServices reads the STW_ALLOWED_SERVICES list, lower-cased; nil
+// allows every service.
+func parseAllowedServices(raw string) map[string]bool {
+	var allowed map[string]bool
+	for _, svc := range strings.Split(raw, ",") {
+		svc = strings.ToLower(strings.TrimSpace(svc))
+		if svc == "" {
+			continue
+		}
+		if allowed == nil {
+			allowed = make(map[string]bool)
+		}
+		allowed[svc] = true
+	}
+	return allowed
+}
+
+// serviceAllowed reports whether results from service are recorded.
+func serviceAllowed(service string) bool {
+	if settings.AllowedServices == nil {
+		return true
+	}
+	service = strings.ToLower(strings.TrimSpace(service))
+	if service == "" {
+		service = defaultService
+	}
+	return settings.AllowedServices[service]
+}
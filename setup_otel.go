@@ -6,21 +6,25 @@ import (
 	"errors"
 	"time"
 
+	promclient "github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/contrib/instrumentation/runtime"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 )
 
 // setupOTelSDK bootstraps the OpenTelemetry pipeline.
 // If it does not return an error, make sure to call shutdown for proper cleanup.
-func setupOTelSDK(ctx context.Context) (shutdown func(context.Context) error, err error) {
+func setupOTelSDK(ctx context.Context, settings *Settings) (shutdown func(context.Context) error, err error) {
 	var shutdownFuncs []func(context.Context) error
 
 	// shutdown calls cleanup functions registered via shutdownFuncs.
@@ -44,34 +48,58 @@ func setupOTelSDK(ctx context.Context) (shutdown func(context.Context) error, er
 	prop := newPropagator()
 	otel.SetTextMapPropagator(prop)
 
+	// Set up resource.
+	res, err := newResource(settings)
+	if err != nil {
+		handleErr(err)
+		return
+	}
+
+	// A single zero-value destination exports according to the OTEL_EXPORTER_OTLP_* env vars.
+	dests := settings.OTLPDestinations
+	if len(dests) == 0 {
+		dests = []otlpDestination{{}}
+	}
+
 	// Set up trace provider.
-	tracerProvider, err := newTraceProvider(ctx)
+	tracerProvider, err := newTraceProvider(ctx, res, dests, settings.MaxSpanEvents)
 	if err != nil {
 		handleErr(err)
 		return
 	}
 	shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
-	otel.SetTracerProvider(tracerProvider)
 
 	// Set up meter provider.
-	meterProvider, err := newMeterProvider(ctx)
+	registry := promclient.NewRegistry()
+	meterProvider, err := newMeterProvider(ctx, res, dests, registry)
 	if err != nil {
 		handleErr(err)
 		return
 	}
 	shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
-	otel.SetMeterProvider(meterProvider)
-
-	runtime.Start(runtime.WithMeterProvider(meterProvider))
 
 	// Set up logger provider.
-	loggerProvider, err := newLoggerProvider(ctx)
+	loggerProvider, err := newLoggerProvider(ctx, res, dests)
 	if err != nil {
 		handleErr(err)
 		return
 	}
 	shutdownFuncs = append(shutdownFuncs, loggerProvider.Shutdown)
+
+	if settings.RuntimeMetrics {
+		// Goroutine count, heap and GC metrics for the process itself.
+		if err = runtime.Start(runtime.WithMeterProvider(meterProvider)); err != nil {
+			handleErr(err)
+			return
+		}
+	}
+
+	// The globals are only set once every provider is up, so a failed setup leaves
+	// the no-op defaults in place and a later retry can still take over.
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
 	global.SetLoggerProvider(loggerProvider)
+	metricsRegistry.Store(registry)
 
 	return
 }
@@ -83,43 +111,83 @@ func newPropagator() propagation.TextMapPropagator {
 	)
 }
 
-func newTraceProvider(ctx context.Context) (*trace.TracerProvider, error) {
-	traceExporter, err := otlptracehttp.New(ctx)
-	if err != nil {
-		return nil, err
+// newResource describes this service, adding deployment.environment when configured.
+func newResource(settings *Settings) (*resource.Resource, error) {
+	if settings.Environment == "" {
+		return resource.Default(), nil
 	}
-
-	traceProvider := trace.NewTracerProvider(
-		trace.WithBatcher(traceExporter),
+	return resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(attribute.String("deployment.environment", settings.Environment)),
 	)
+}
+
+func newTraceProvider(ctx context.Context, res *resource.Resource, dests []otlpDestination, maxEvents int) (*trace.TracerProvider, error) {
+	// Raise the SDK event limit (128 unless OTEL_SPAN_EVENT_COUNT_LIMIT is set) so
+	// it never evicts events below STW_MAX_SPAN_EVENTS.
+	limits := trace.NewSpanLimits()
+	if limits.EventCountLimit >= 0 && limits.EventCountLimit < maxEvents {
+		limits.EventCountLimit = maxEvents
+	}
+	opts := []trace.TracerProviderOption{trace.WithResource(res), trace.WithRawSpanLimits(limits)}
+	for _, d := range dests {
+		exporterOpts, err := d.traceOptions()
+		if err != nil {
+			return nil, err
+		}
+		traceExporter, err := otlptracehttp.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, trace.WithBatcher(watchSpanExporter(d, traceExporter)))
+	}
+
+	traceProvider := trace.NewTracerProvider(opts...)
 	return traceProvider, nil
 }
 
-func newMeterProvider(ctx context.Context) (*metric.MeterProvider, error) {
-	metricExporter, err := otlpmetrichttp.New(ctx)
+func newMeterProvider(ctx context.Context, res *resource.Resource, dests []otlpDestination, registry *promclient.Registry) (*metric.MeterProvider, error) {
+	// The Prometheus reader only backs the on-demand OpenMetrics dump.
+	promExporter, err := prometheus.New(prometheus.WithRegisterer(registry))
 	if err != nil {
 		return nil, err
 	}
-
-	meterProvider := metric.NewMeterProvider(
-		metric.WithReader(
+	opts := []metric.Option{metric.WithResource(res), metric.WithReader(promExporter)}
+	for _, d := range dests {
+		exporterOpts, err := d.metricOptions()
+		if err != nil {
+			return nil, err
+		}
+		metricExporter, err := otlpmetrichttp.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, metric.WithReader(
 			metric.NewPeriodicReader(
-				metricExporter,
+				gatedExporter{watchMetricExporter(d, metricExporter), exporterHealth(d.label())},
 				metric.WithInterval(3*time.Second),
 			),
-		),
-	)
+		))
+	}
+
+	meterProvider := metric.NewMeterProvider(opts...)
 	return meterProvider, nil
 }
 
-func newLoggerProvider(ctx context.Context) (*log.LoggerProvider, error) {
-	logExporter, err := otlploghttp.New(ctx)
-	if err != nil {
-		return nil, err
+func newLoggerProvider(ctx context.Context, res *resource.Resource, dests []otlpDestination) (*log.LoggerProvider, error) {
+	opts := []log.LoggerProviderOption{log.WithResource(res)}
+	for _, d := range dests {
+		exporterOpts, err := d.logOptions()
+		if err != nil {
+			return nil, err
+		}
+		logExporter, err := otlploghttp.New(ctx, exporterOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, log.WithProcessor(log.NewBatchProcessor(watchLogExporter(d, logExporter))))
 	}
 
-	loggerProvider := log.NewLoggerProvider(
-		log.WithProcessor(log.NewBatchProcessor(logExporter)),
-	)
+	loggerProvider := log.NewLoggerProvider(opts...)
 	return loggerProvider, nil
 }
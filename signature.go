@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Signature formats accepted by STW_SIGNATURE_FORMAT.
+const (
+	signatureHex    = "hex"
+	signatureBase64 = "base64"
+	// signatureGitHub is hex prefixed with "sha256=", as in X-Hub-Signature-256.
+	signatureGitHub = "github"
+)
+
+// parseSignatureFormat validates STW_SIGNATURE_FORMAT, defaulting to hex.
+func parseSignatureFormat(raw string) (string, error) {
+	f, ok := normalizeSignatureFormat(raw)
+	if !ok {
+		return "", fmt.Errorf("invalid value for env var STW_SIGNATURE_FORMAT %s: must be hex, base64 or github", raw)
+	}
+	return f, nil
+}
+
+// normalizeSignatureFormat lowercases a signature format, defaulting an empty
+// one to hex, and reports whether it is supported.
+func normalizeSignatureFormat(raw string) (string, bool) {
+	switch f := strings.ToLower(strings.TrimSpace(raw)); f {
+	case "":
+		return signatureHex, true
+	case signatureHex, signatureBase64, signatureGitHub:
+		return f, true
+	default:
+		return "", false
+	}
+}
+
+// encodeSignature returns the HMAC-SHA256 of body under secret, encoded in format.
+func encodeSignature(format string, secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	sum := mac.Sum(nil)
+	switch format {
+	case signatureBase64:
+		return base64.StdEncoding.EncodeToString(sum)
+	case signatureGitHub:
+		return "sha256=" + hex.EncodeToString(sum)
+	default:
+		return hex.EncodeToString(sum)
+	}
+}
+
+// validSignature reports whether got is the signature of body in format. Hex
+// digests are compared case-insensitively.
+func validSignature(format string, secret, body []byte, got string) bool {
+	want := encodeSignature(format, secret, body)
+	got = strings.TrimSpace(got)
+	if format != signatureBase64 {
+		got = strings.ToLower(got)
+	}
+	return hmac.Equal([]byte(got), []byte(want))
+}
+
+// checkQueryToken verifies the STW_AUTH_QUERY_PARAM token and removes it from
+// r.URL, so nothing that logs or traces the URL later can leak it.
+func checkQueryToken(r *http.Request) bool {
+	q := r.URL.Query()
+	token := q.Get(settings.AuthQueryParam)
+	q.Del(settings.AuthQueryParam)
+	r.URL.RawQuery = q.Encode()
+	return subtle.ConstantTimeCompare([]byte(token), []byte(settings.AuthQueryToken)) == 1
+}
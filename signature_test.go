@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// HMAC-SHA256 of signedBody under signingSecret.
+const (
+	signingSecret    = "secret"
+	signedBody       = `{"serverId": 1}`
+	signedBodyHex    = "7c0632527ae9b5a29ac63698b6e1ed3670038bd499b8d5912b743c078ec7a4da"
+	signedBodyBase64 = "fAYyUnrptaKaxjaYtuHtNnADi9SZuNWRK3Q8B47HpNo="
+)
+
+func TestValidSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		header string
+		valid  bool
+	}{
+		{"hex", signatureHex, signedBodyHex, true},
+		{"hex upper case", signatureHex, strings.ToUpper(signedBodyHex), true},
+		{"hex with whitespace", signatureHex, " " + signedBodyHex + "\n", true},
+		{"hex wrong digest", signatureHex, strings.Repeat("0", 64), false},
+		{"hex sent as base64", signatureHex, signedBodyBase64, false},
+		{"base64", signatureBase64, signedBodyBase64, true},
+		{"base64 is case-sensitive", signatureBase64, strings.ToLower(signedBodyBase64), false},
+		{"base64 sent as hex", signatureBase64, signedBodyHex, false},
+		{"github", signatureGitHub, "sha256=" + signedBodyHex, true},
+		{"github upper case", signatureGitHub, "SHA256=" + strings.ToUpper(signedBodyHex), true},
+		{"github without prefix", signatureGitHub, signedBodyHex, false},
+		{"github sha1 prefix", signatureGitHub, "sha1=" + signedBodyHex, false},
+		{"empty", signatureHex, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validSignature(tt.format, []byte(signingSecret), []byte(signedBody), tt.header); got != tt.valid {
+				t.Errorf("validSignature(%s, %q) = %t, want %t", tt.format, tt.header, got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidSignatureWrongSecret(t *testing.T) {
+	for _, format := range []string{signatureHex, signatureBase64, signatureGitHub} {
+		sig := encodeSignature(format, []byte("other"), []byte(signedBody))
+		if validSignature(format, []byte(signingSecret), []byte(signedBody), sig) {
+			t.Errorf("%s signature under another secret was accepted", format)
+		}
+	}
+}
+
+func TestWebhookRejectsWrongSignature(t *testing.T) {
+	for _, format := range []string{signatureHex, signatureBase64, signatureGitHub} {
+		t.Run(format, func(t *testing.T) {
+			useSettings(t, map[string]string{
+				"STW_WEBHOOK_SECRET":   signingSecret,
+				"STW_SIGNATURE_FORMAT": format,
+				"STW_HISTORY_SIZE":     "0",
+			})
+			useInstruments(t, nil)
+			send := func(sig string) int {
+				req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(signedBody))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("X-Signature", sig)
+				rec := httptest.NewRecorder()
+				webhookHandler(rec, req)
+				return rec.Code
+			}
+			if code := send(encodeSignature(format, []byte(signingSecret), []byte(signedBody))); code != http.StatusOK {
+				t.Errorf("valid signature: status = %d, want %d", code, http.StatusOK)
+			}
+			if code := send(encodeSignature(format, []byte("other"), []byte(signedBody))); code != http.StatusUnauthorized {
+				t.Errorf("wrong signature: status = %d, want %d", code, http.StatusUnauthorized)
+			}
+			if code := send(""); code != http.StatusUnauthorized {
+				t.Errorf("missing signature: status = %d, want %d", code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// resultSink is an additional destination that every accepted result is sent to,
+// alongside the OTel pipeline.
+type resultSink interface {
+	// Name identifies the sink in logs and configuration.
+	Name() string
+	// Kind is the sink type, such as "forward" or "statsd".
+	Kind() string
+	// Target is where results go, for traces. It must not contain credentials.
+	Target() string
+	// Send delivers a single result.
+	Send(ctx context.Context, res storedResult) error
+	// Close flushes anything buffered and releases resources.
+	Close(ctx context.Context) error
+}
+
+// managedSink is a configured sink that can be enabled or disabled at runtime.
+type managedSink struct {
+	resultSink
+	enabled atomic.Bool
+	health  integrationHealth
+}
+
+// sinks holds the sinks configured at startup.
+var sinks []*managedSink
+
+// sinkStateMu serializes writes of the persisted sink state file.
+var sinkStateMu sync.Mutex
+
+// registerSink adds an enabled sink.
+func registerSink(s resultSink) {
+	m := &managedSink{resultSink: s}
+	m.enabled.Store(true)
+	sinks = append(sinks, m)
+}
+
+// findSink returns the sink with the given name, or nil.
+func findSink(name string) *managedSink {
+	for _, s := range sinks {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// sinkSends tracks the deliveries started by sendToSinks, so shutdown can
+// wait for them before closing the sinks.
+var sinkSends sync.WaitGroup
+
+// sendToSinks delivers a result to every enabled sink in the background, so
+// the webhook response never waits on a sink. Failures are logged.
+func sendToSinks(ctx context.Context, res storedResult) {
+	if len(sinks) == 0 {
+		return
+	}
+	sinkSends.Go(func() {
+		for _, s := range sinks {
+			if !s.enabled.Load() {
+				continue
+			}
+			if err := s.send(ctx, res); err != nil {
+				logFrom(ctx).Errorf("Sink %s failed to send result %d: %v", s.Name(), res.Payload.ResultID, err)
+				sinkErrorsVar.Add(s.Name(), 1)
+			}
+		}
+	})
+}
+
+// send delivers a result to the sink in a child span of ctx, so slow or
+// failing sinks show up in the webhook trace. For sinks that queue results,
+// the span covers the enqueueing only. The delivery is detached from ctx, a
+// request context that is canceled once the response is written, and bounded
+// by STW_SINK_TIMEOUT instead.
+func (m *managedSink) send(ctx context.Context, res storedResult) (err error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), settings.SinkTimeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "sink.send", trace.WithAttributes(
+		attribute.String("sink.name", m.Name()),
+		attribute.String("sink.type", m.Kind()),
+		attribute.String("sink.target", m.Target()),
+		attribute.Int("result_id", res.Payload.ResultID),
+	))
+	start := time.Now()
+	defer func() {
+		m.health.observe(err)
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(
+			attribute.String("sink.outcome", outcome),
+			attribute.Float64("sink.duration_ms", float64(time.Since(start).Microseconds())/1000),
+		)
+		span.End()
+	}()
+	return m.Send(ctx, res)
+}
+
+// urlTarget reduces a sink URL to its scheme, host and path, dropping any
+// user info and query that could hold credentials.
+func urlTarget(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// closeSinks waits for running deliveries, then closes every configured sink,
+// joining their errors. Deliveries still running when ctx is done are
+// abandoned.
+func closeSinks(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		sinkSends.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("Shutdown timed out waiting for sink deliveries")
+	}
+	var err error
+	for _, s := range sinks {
+		err = errors.Join(err, s.Close(ctx))
+	}
+	return err
+}
+
+// loadSinkState applies the enabled flags persisted in path, if it exists.
+func loadSinkState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state map[string]bool
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parsing sink state file %s: %w", path, err)
+	}
+	for name, enabled := range state {
+		s := findSink(name)
+		if s == nil {
+			log.Warnf("Ignoring unknown sink %q in %s", name, path)
+			continue
+		}
+		s.enabled.Store(enabled)
+		if !enabled {
+			log.Infof("Sink %s disabled by %s", name, path)
+		}
+	}
+	return nil
+}
+
+// saveSinkState writes the current enabled flags to path, replacing it atomically.
+func saveSinkState(path string) error {
+	sinkStateMu.Lock()
+	defer sinkStateMu.Unlock()
+
+	state := make(map[string]bool, len(sinks))
+	for _, s := range sinks {
+		state[s.Name()] = s.enabled.Load()
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sink-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
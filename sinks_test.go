@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingSink waits for release before checking the context it was sent with.
+type blockingSink struct {
+	release chan struct{}
+	ctxErr  error
+	left    time.Duration
+}
+
+func (s *blockingSink) Name() string                { return "blocking" }
+func (s *blockingSink) Kind() string                { return "test" }
+func (s *blockingSink) Target() string              { return "memory" }
+func (s *blockingSink) Close(context.Context) error { return nil }
+
+func (s *blockingSink) Send(ctx context.Context, _ storedResult) error {
+	<-s.release
+	s.ctxErr = ctx.Err()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.left = time.Until(deadline)
+	}
+	return nil
+}
+
+func TestSinksSendAfterRequestContextIsCanceled(t *testing.T) {
+	useSettings(t, map[string]string{"STW_HISTORY_SIZE": "0", "STW_SINK_TIMEOUT": "5s"})
+	useInstruments(t, nil)
+	prev := sinks
+	sinks = nil
+	t.Cleanup(func() { sinks = prev })
+	sink := &blockingSink{release: make(chan struct{})}
+	registerSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/webhook", strings.NewReader(testPayload))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	webhookHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	cancel()
+	close(sink.release)
+	sinkSends.Wait()
+
+	if sink.ctxErr != nil {
+		t.Errorf("sink context error = %v, want a live context", sink.ctxErr)
+	}
+	if sink.left <= 0 || sink.left > 5*time.Second {
+		t.Errorf("sink deadline in %s, want within STW_SINK_TIMEOUT", sink.left)
+	}
+}
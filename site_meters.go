@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// siteHistograms are the speedtest histograms of one site's own meter.
+type siteHistograms struct {
+	ping, download, upload metric.Float64Histogram
+}
+
+var (
+	siteMetersMu sync.Mutex
+	siteMeters   = make(map[string]*siteHistograms)
+	// siteLimitWarned ensures the STW_MAX_SITES warning is logged once.
+	siteLimitWarned bool
+)
+
+// histogramsForSite returns the cached histograms of a site's meter, creating
+// them on first use. It returns nil when per-site meters are disabled, the
+// site name is empty, the STW_MAX_SITES limit has been reached, or the
+// instruments can't be created; callers then use the shared histograms.
+func histogramsForSite(site string) *siteHistograms {
+	if !settings.PerSiteMeters || site == "" {
+		return nil
+	}
+
+	siteMetersMu.Lock()
+	defer siteMetersMu.Unlock()
+
+	if h, ok := siteMeters[site]; ok {
+		return h
+	}
+	if len(siteMeters) >= settings.MaxSites {
+		if !siteLimitWarned {
+			log.Warnf("STW_MAX_SITES (%d) reached; results of new sites such as %q use the shared meter", settings.MaxSites, site)
+			siteLimitWarned = true
+		}
+		return nil
+	}
+
+	prefix := sitePrefix(site)
+	m := otel.Meter("speedtest-webhook/site/" + site)
+	ping, err := m.Float64Histogram(prefix+".speedtest.ping", metric.WithDescription("Ping latency"), metric.WithUnit("ms"))
+	if err != nil {
+		log.Errorf("Failed to create ping histogram for site %q: %v", site, err)
+		return nil
+	}
+	download, err := m.Float64Histogram(prefix+".speedtest.download", metric.WithDescription("Download speed"), metric.WithUnit(settings.SpeedUnit.OtelUnit))
+	if err != nil {
+		log.Errorf("Failed to create download histogram for site %q: %v", site, err)
+		return nil
+	}
+	upload, err := m.Float64Histogram(prefix+".speedtest.upload", metric.WithDescription("Upload speed"), metric.WithUnit(settings.SpeedUnit.OtelUnit))
+	if err != nil {
+		log.Errorf("Failed to create upload histogram for site %q: %v", site, err)
+		return nil
+	}
+
+	h := &siteHistograms{ping: ping, download: download, upload: upload}
+	siteMeters[site] = h
+	return h
+}
+
+// sitePrefix turns a site name into a metric name prefix: lower case, with
+// anything other than letters, digits and underscores replaced by underscores.
+// OTel instrument names must start with a letter, so a leading "site_" is added
+// when needed.
+func sitePrefix(site string) string {
+	prefix := strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(site))
+	if prefix[0] < 'a' || prefix[0] > 'z' {
+		prefix = "site_" + prefix
+	}
+	return prefix
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRejectedPayloadDoesNotClaimSite(t *testing.T) {
+	useSettings(t, map[string]string{"STW_PER_SITE_METERS": "true", "STW_TRANSFORM_EXPR": `{"download": serverId == 2 ? "bad" : download}`, "STW_HISTORY_SIZE": "0"})
+	useInstruments(t, nil)
+	t.Cleanup(func() {
+		siteMetersMu.Lock()
+		clear(siteMeters)
+		siteMetersMu.Unlock()
+	})
+	h := http.HandlerFunc(webhookHandler)
+
+	if rec := postWebhook(h, `{"site_name": "rejected", "serverId": 2, "ping": 1, "download": 1, "upload": 1}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if rec := postWebhook(h, `{"site_name": "accepted", "serverId": 1, "ping": 1, "download": 1, "upload": 1}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	siteMetersMu.Lock()
+	defer siteMetersMu.Unlock()
+	if _, ok := siteMeters["rejected"]; ok {
+		t.Error("rejected payload claimed a per-site meter")
+	}
+	if _, ok := siteMeters["accepted"]; !ok {
+		t.Error("accepted payload has no per-site meter")
+	}
+}
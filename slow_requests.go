@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// requestPhases splits a webhook request's time into consecutive phases for
+// the STW_SLOW_REQUEST_THRESHOLD warning. Each lap charges the time since the
+// previous one to a phase, so the phases add up to the total. It is only used
+// by the goroutine serving the request. A nil *requestPhases ignores laps.
+type requestPhases struct {
+	start time.Time
+	last  time.Time
+	names []string
+	times map[string]time.Duration
+}
+
+type requestPhasesKey struct{}
+
+// withRequestPhases starts timing the phases of a request when
+// STW_SLOW_REQUEST_THRESHOLD is set, returning nil otherwise.
+func withRequestPhases(ctx context.Context) (context.Context, *requestPhases) {
+	if settings.SlowRequestThreshold == 0 {
+		return ctx, nil
+	}
+	now := time.Now()
+	p := &requestPhases{start: now, last: now, times: make(map[string]time.Duration)}
+	return context.WithValue(ctx, requestPhasesKey{}, p), p
+}
+
+// phasesFrom returns the phases timed for ctx, or nil.
+func phasesFrom(ctx context.Context) *requestPhases {
+	p, _ := ctx.Value(requestPhasesKey{}).(*requestPhases)
+	return p
+}
+
+// lap charges the time since the previous lap to phase.
+func (p *requestPhases) lap(phase string) {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	if _, ok := p.times[phase]; !ok {
+		p.names = append(p.names, phase)
+	}
+	p.times[phase] += now.Sub(p.last)
+	p.last = now
+}
+
+// logIfSlow warns when the request took longer than STW_SLOW_REQUEST_THRESHOLD,
+// with the time of each phase and the slowest one. The total matches what
+// otelhttp records in http.server.request.duration, minus the middleware.
+func (p *requestPhases) logIfSlow(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	p.lap("respond")
+	total := p.last.Sub(p.start)
+	if total <= settings.SlowRequestThreshold {
+		return
+	}
+	fields := log.Fields{"duration_ms": total.Milliseconds()}
+	slowest := ""
+	for _, name := range p.names {
+		fields["phase."+name+"_ms"] = p.times[name].Milliseconds()
+		if slowest == "" || p.times[name] > p.times[slowest] {
+			slowest = name
+		}
+	}
+	fields["slowest_phase"] = slowest
+	logFrom(ctx).WithFields(fields).Warnf("Slow webhook request took %s, mostly in %s", total.Round(time.Millisecond), slowest)
+}
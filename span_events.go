@@ -0,0 +1,50 @@
+package main
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanEvents adds result events to the webhook span, up to STW_MAX_SPAN_EVENTS.
+// When a batch holds more results than that, the last slot is kept for a
+// summary event, so backends never receive an oversized span and the SDK
+// doesn't evict the first events.
+type spanEvents struct {
+	span    trace.Span
+	limit   int
+	added   int
+	omitted int
+}
+
+// newSpanEvents prepares for expected result events on span.
+func newSpanEvents(span trace.Span, maxEvents, expected int) *spanEvents {
+	limit := maxEvents
+	if expected > maxEvents {
+		limit = maxEvents - 1
+	}
+	return &spanEvents{span: span, limit: limit}
+}
+
+// add records an event, or counts it as omitted once the limit is reached.
+func (e *spanEvents) add(name string, attrs ...attribute.KeyValue) {
+	if e.added >= e.limit {
+		e.omitted++
+		return
+	}
+	e.added++
+	e.span.AddEvent(name, trace.WithAttributes(attrs...))
+}
+
+// summarize adds a speedtest.results.summary event if any event was omitted.
+func (e *spanEvents) summarize(t resultTally) {
+	if e.omitted == 0 {
+		return
+	}
+	e.span.AddEvent("speedtest.results.summary", trace.WithAttributes(
+		attribute.Int("results.count", t.results),
+		attribute.Int("results.stale", t.stale),
+		attribute.Int("results.filtered", t.filtered),
+		attribute.Int("events.recorded", e.added),
+		attribute.Int("events.omitted", e.omitted),
+	))
+}
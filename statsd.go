@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// statsdQueueSize bounds the lines waiting to be sent.
+	statsdQueueSize = 1000
+	// statsdMaxPacket keeps datagrams within a typical Ethernet MTU.
+	statsdMaxPacket = 1432
+)
+
+// statsdConfig configures the StatsD sink.
+type statsdConfig struct {
+	Addr          string
+	Prefix        string
+	Tags          bool
+	FlushInterval time.Duration
+}
+
+// statsdSink sends ping as a timer and download/upload as gauges to a StatsD
+// server over UDP. Send only queues the lines; a background loop packs them
+// into datagrams, so a slow or missing server never blocks a webhook. Dropped
+// lines and failed writes are counted in sink_errors but not logged.
+type statsdSink struct {
+	cfg  statsdConfig
+	conn net.Conn
+
+	// mu guards closed, so Send never writes to a closed queue.
+	mu      sync.RWMutex
+	closed  bool
+	lines   chan string
+	stopped chan struct{}
+}
+
+func newStatsdSink(cfg statsdConfig) (*statsdSink, error) {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &statsdSink{
+		cfg:     cfg,
+		conn:    conn,
+		lines:   make(chan string, statsdQueueSize),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *statsdSink) Name() string   { return "statsd" }
+func (s *statsdSink) Kind() string   { return "statsd" }
+func (s *statsdSink) Target() string { return s.cfg.Addr }
+
+// Send queues the metrics of a successful result.
+func (s *statsdSink) Send(ctx context.Context, res storedResult) error {
+	if res.Outcome == outcomeFailure {
+		return nil
+	}
+	p := res.Payload
+	tags := ""
+	if s.cfg.Tags {
+		tags = s.tags(res)
+	}
+	lines := []string{
+		s.line("ping", strconv.FormatFloat(roundValue(float64(p.Ping)), 'f', -1, 64), "ms", tags),
+		s.line("download", strconv.FormatFloat(roundValue(float64(p.Download)/settings.SpeedUnit.Divisor), 'f', -1, 64), "g", tags),
+		s.line("upload", strconv.FormatFloat(roundValue(float64(p.Upload)/settings.SpeedUnit.Divisor), 'f', -1, 64), "g", tags),
+	}
+	if p.PacketLoss != nil {
+		lines = append(lines, s.line("packet_loss", strconv.FormatFloat(roundValue(float64(*p.PacketLoss)), 'f', -1, 64), "g", tags))
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil
+	}
+	for _, l := range lines {
+		select {
+		case s.lines <- l:
+		default:
+			sinkErrorsVar.Add(s.Name(), 1)
+		}
+	}
+	return nil
+}
+
+func (s *statsdSink) line(name, value, kind, tags string) string {
+	return s.cfg.Prefix + name + ":" + value + "|" + kind + tags
+}
+
+// tags renders the result attributes as a DogStatsD tag suffix.
+func (s *statsdSink) tags(res storedResult) string {
+	p := res.Payload
+	pairs := [][2]string{
+		{"server_id", strconv.Itoa(p.ServerID)},
+		{"server_name", p.ServerName},
+		{"isp", p.ISP},
+	}
+	if res.Tenant != "" {
+		pairs = append(pairs, [2]string{"tenant", res.Tenant})
+	}
+	for _, a := range settings.StaticMetricAttributes {
+		pairs = append(pairs, [2]string{sanitizeLabelName(string(a.Key)), a.Value.AsString()})
+	}
+	var b strings.Builder
+	b.WriteString("|#")
+	for i, kv := range pairs {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv[0])
+		b.WriteByte(':')
+		b.WriteString(statsdTagValue(kv[1]))
+	}
+	return b.String()
+}
+
+// statsdTagValue replaces the characters that delimit StatsD lines and tags.
+func statsdTagValue(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', ',', '#', ':', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+// run packs queued lines into datagrams, sending each when it is full or
+// when the flush interval elapses.
+func (s *statsdSink) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	var packet bytes.Buffer
+	for {
+		select {
+		case l, ok := <-s.lines:
+			if !ok {
+				s.write(&packet)
+				return
+			}
+			if packet.Len() > 0 && packet.Len()+1+len(l) > statsdMaxPacket {
+				s.write(&packet)
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(l)
+		case <-ticker.C:
+			s.write(&packet)
+		}
+	}
+}
+
+func (s *statsdSink) write(packet *bytes.Buffer) {
+	if packet.Len() == 0 {
+		return
+	}
+	if _, err := s.conn.Write(packet.Bytes()); err != nil {
+		sinkErrorsVar.Add(s.Name(), 1)
+	}
+	packet.Reset()
+}
+
+// Close sends the queued lines and closes the socket.
+func (s *statsdSink) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.lines)
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := s.conn.Close(); err != nil {
+		return fmt.Errorf("closing statsd socket: %w", err)
+	}
+	return nil
+}
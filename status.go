@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// startedAt is when the process started.
+var startedAt = time.Now()
+
+// lastReceivedAt is the Unix time in nanoseconds of the last webhook with a
+// valid payload, or 0 if none has arrived yet.
+var lastReceivedAt atomic.Int64
+
+// statusResponse is the body of GET /status.
+type statusResponse struct {
+	StartedAt      time.Time  `json:"started_at"`
+	UptimeSeconds  float64    `json:"uptime_seconds"`
+	LastReceivedAt *time.Time `json:"last_received_at"`
+	Stale          bool       `json:"stale"`
+	Ready          bool       `json:"ready"`
+}
+
+// markReceived records that a result has just been received, once per result
+// of a batch.
+func markReceived() {
+	lastReceivedAt.Store(time.Now().UnixNano())
+	receivedVar.Add(1)
+}
+
+// statusHandler reports uptime and when the last webhook arrived. With
+// STW_STALE_AFTER set, stale is true once that long has passed without a
+// webhook, counting from startup until the first one arrives.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	now := time.Now()
+	resp := statusResponse{
+		StartedAt:     startedAt.UTC(),
+		UptimeSeconds: now.Sub(startedAt).Seconds(),
+		Ready:         ready.Load(),
+	}
+	since := startedAt
+	if ns := lastReceivedAt.Load(); ns != 0 {
+		last := time.Unix(0, ns).UTC()
+		resp.LastReceivedAt = &last
+		since = last
+	}
+	resp.Stale = settings.StaleAfter > 0 && now.Sub(since) > settings.StaleAfter
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// streamable reports whether the request body may be a batch large enough to
+// decode as a stream under STW_STREAM_BATCH_BYTES. Signed requests are always
+// buffered, since the signature covers the whole body and must be checked
+// before anything is recorded.
+func streamable(r *http.Request) bool {
+	if settings.StreamBatchBytes == 0 || settings.WebhookSecret != "" {
+		return false
+	}
+	// Chunked bodies have no Content-Length and may be of any size.
+	if r.ContentLength >= 0 && r.ContentLength <= settings.StreamBatchBytes {
+		return false
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	// Form bodies starting with '[' are JSON too, as payloadJSON treats them.
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/x-www-form-urlencoded")
+}
+
+// startsWithArray skips leading whitespace in br and reports whether a JSON
+// array follows. Read errors are left for the next read to report.
+func startsWithArray(br *bufio.Reader) bool {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return false
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		default:
+			return b[0] == '['
+		}
+	}
+}
+
+// streamBatch decodes and records a batch one element at a time, so memory
+// stays bounded by the largest result rather than the whole batch. Unlike a
+// buffered batch, results are recorded as they are read: a malformed element
+// stops the batch with an error, but the results before it stay recorded.
+func streamBatch(ctx context.Context, w http.ResponseWriter, src io.Reader, tenant, client string) {
+	span := trace.SpanFromContext(ctx)
+	logger := logFrom(ctx)
+	phases := phasesFrom(ctx)
+	span.SetAttributes(attribute.Bool("speedtest.batch.streamed", true))
+
+	// The batch size is unknown up front, so the summary slot is always kept.
+	events := newSpanEvents(span, settings.MaxSpanEvents, math.MaxInt)
+	var tally resultTally
+	dec := json.NewDecoder(src)
+	err := expectDelim(dec, '[')
+	for err == nil && dec.More() {
+		var elem json.RawMessage
+		if err = dec.Decode(&elem); err != nil {
+			break
+		}
+		// Elements beyond STW_MAX_BATCH_SIZE are only read, to count them.
+		if tally.dropped > 0 {
+			tally.dropped++
+			continue
+		}
+		if settings.MaxBatchSize > 0 && tally.results == settings.MaxBatchSize {
+			if _, err = capBatch(tally.results + 1); err != nil {
+				break
+			}
+			tally.dropped = 1
+			continue
+		}
+		var payload WebhookPayload
+		if payload, err = decodePayload(elem); err != nil {
+			break
+		}
+		if payload, err = preparePayload(payload); err != nil {
+			break
+		}
+		phases.lap("decode")
+		tally.add(processResult(ctx, events, tenant, client, payload))
+	}
+	if err == nil {
+		err = expectDelim(dec, ']')
+	}
+	if err == nil && tally.results == 0 {
+		err = errEmptyBatch
+	}
+	if err == nil && settings.RejectTrailingData {
+		if _, tokenErr := dec.Token(); tokenErr != io.EOF {
+			err = errTrailingData
+		}
+	}
+	span.SetAttributes(attribute.Int("speedtest.batch.size", tally.results))
+	if tally.dropped > 0 {
+		logger.Warnf("Streamed batch exceeds STW_MAX_BATCH_SIZE, dropped the last %d of %d results", tally.dropped, tally.results+tally.dropped)
+		span.SetAttributes(attribute.Int("speedtest.batch.dropped", tally.dropped))
+	}
+
+	if err != nil {
+		err = fmt.Errorf("result %d: %w", tally.results, err)
+		span.RecordError(err)
+		var status int
+		var msg string
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || errors.Is(err, errInvalidGzip) {
+			status, msg = bodyErrorResponse(err)
+		} else {
+			status, msg = decodeErrorResponse(logger, err)
+		}
+		if tally.results > 0 {
+			logger.Warnf("Streamed batch stopped after %d results: %v", tally.results, err)
+			msg = fmt.Sprintf("%s; %d results before it were recorded", msg, tally.results)
+			events.summarize(tally)
+		}
+		http.Error(w, msg, status)
+		return
+	}
+	logger.Infof("Received streamed batch of %d results", tally.results)
+	respondResults(ctx, w, events, tally)
+}
+
+// expectDelim reads the next token from dec and fails unless it is delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, got %v", delim, tok)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStreamedBatchUsesStreamLimit(t *testing.T) {
+	useSettings(t, map[string]string{
+		"STW_HISTORY_SIZE":       "0",
+		"STW_MAX_BODY_BYTES":     "512",
+		"STW_STREAM_BATCH_BYTES": "256",
+		"STW_MAX_STREAM_BYTES":   "4096",
+	})
+	useInstruments(t, nil)
+	h := http.HandlerFunc(webhookHandler)
+
+	batch := func(n int) string {
+		return "[" + strings.TrimSuffix(strings.Repeat(testPayload+",", n), ",") + "]"
+	}
+	if body := batch(5); int64(len(body)) <= settings.MaxBodyBytes || int64(len(body)) >= settings.MaxStreamBytes {
+		t.Fatalf("batch of %d bytes is not between the limits", len(body))
+	}
+	if rec := postWebhook(h, batch(5)); rec.Code != http.StatusOK {
+		t.Errorf("batch over STW_MAX_BODY_BYTES: status = %d, body %q", rec.Code, rec.Body)
+	}
+	if rec := postWebhook(h, batch(40)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("batch over STW_MAX_STREAM_BYTES: status = %d, want 413", rec.Code)
+	}
+	single := `{"result_id": 1, "serverName": "` + strings.Repeat("x", 600) + `"}`
+	if rec := postWebhook(h, single); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("single result over STW_MAX_BODY_BYTES: status = %d, want 413", rec.Code)
+	}
+}
+
+func TestStreamBatchBytesBelowMaxStreamBytes(t *testing.T) {
+	t.Setenv("STW_STREAM_BATCH_BYTES", "4096")
+	t.Setenv("STW_MAX_STREAM_BYTES", "4096")
+	if _, err := loadSettings(); err == nil || !strings.Contains(err.Error(), "STW_STREAM_BATCH_BYTES") {
+		t.Errorf("loadSettings error = %v, want STW_STREAM_BATCH_BYTES error", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// validTenant restricts tenant path segments to short, attribute-friendly names.
+var validTenant = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// parseTenants validates the STW_TENANTS allowlist.
+func parseTenants(raw string) (map[string]bool, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	tenants := make(map[string]bool)
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.TrimSpace(t)
+		if !validTenant.MatchString(t) {
+			return nil, fmt.Errorf("invalid value for env var STW_TENANTS: %q is not a valid tenant name", t)
+		}
+		tenants[t] = true
+	}
+	return tenants, nil
+}
+
+// tenantAllowed reports whether a /webhook/{tenant} segment is accepted.
+func tenantAllowed(tenant string) bool {
+	if !validTenant.MatchString(tenant) {
+		return false
+	}
+	return settings.Tenants == nil || settings.Tenants[tenant]
+}
+
+// tenantAttributes returns the tenant attribute, or nil for the plain /webhook route.
+func tenantAttributes(tenant string) []attribute.KeyValue {
+	if tenant == "" {
+		return nil
+	}
+	return []attribute.KeyValue{attribute.String("tenant", tenant)}
+}
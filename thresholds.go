@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// thresholdEnvVars are the env vars of thresholdSettings, which
+// STW_THRESHOLDS_FILE may set.
+var thresholdEnvVars = []string{
+	"STW_BUFFERBLOAT_THRESHOLDS",
+	"STW_QUALITY_GOOD",
+	"STW_QUALITY_OK",
+	"STW_CRITICAL_THRESHOLDS",
+	"STW_DEVIATION_PERCENT",
+	"STW_DEVIATION_ALERT",
+}
+
+// thresholdSettings are the limits results are graded and alerted against,
+// which can be tuned without a restart.
+type thresholdSettings struct {
+	// BufferbloatThresholds are the upper latency increases, in ms, for the grades A to D.
+	BufferbloatThresholds []float64
+	// QualityGood and QualityOK are the limits for the good and ok quality tiers;
+	// results meeting neither are poor. Tiers are off when both are nil.
+	QualityGood *qualityThresholds
+	QualityOK   *qualityThresholds
+	// CriticalThresholds are the limits below which a result's span is marked
+	// as an error; nil only flags failed tests.
+	CriticalThresholds *qualityThresholds
+	// DeviationPercent is how far below (speeds) or above (ping) the baseline
+	// median a result must be for DeviationAlert to fire.
+	DeviationPercent float64
+	DeviationAlert   bool
+}
+
+// activeThresholds holds the thresholds loaded by the last SIGHUP, if any.
+var activeThresholds atomic.Pointer[thresholdSettings]
+
+// thresholds returns the thresholds in effect. Callers should read it once
+// per result, so a concurrent reload never mixes old and new values.
+func thresholds() *thresholdSettings {
+	if t := activeThresholds.Load(); t != nil {
+		return t
+	}
+	return &settings.thresholdSettings
+}
+
+// loadThresholds parses the threshold env vars.
+func loadThresholds() (thresholdSettings, error) {
+	var t thresholdSettings
+	var err error
+	if t.BufferbloatThresholds, err = parseBufferbloatThresholds(os.Getenv("STW_BUFFERBLOAT_THRESHOLDS")); err != nil {
+		return t, err
+	}
+	if t.QualityGood, err = parseQualityThresholds("STW_QUALITY_GOOD"); err != nil {
+		return t, err
+	}
+	if t.QualityOK, err = parseQualityThresholds("STW_QUALITY_OK"); err != nil {
+		return t, err
+	}
+	if t.CriticalThresholds, err = parseQualityThresholds("STW_CRITICAL_THRESHOLDS"); err != nil {
+		return t, err
+	}
+	deviation, err := envInt("STW_DEVIATION_PERCENT", 30)
+	if err != nil {
+		return t, err
+	}
+	if deviation <= 0 {
+		return t, fmt.Errorf("invalid value for env var STW_DEVIATION_PERCENT %d: must be greater than 0", deviation)
+	}
+	t.DeviationPercent = float64(deviation)
+	if t.DeviationAlert, err = envBool("STW_DEVIATION_ALERT", false); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// applyThresholdsFile sets the env vars listed in path, one KEY=value per
+// line, so loadThresholds reads them. Blank lines and lines starting with #
+// are skipped; keys other than thresholdEnvVars are rejected.
+func applyThresholdsFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading STW_THRESHOLDS_FILE: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || !slices.Contains(thresholdEnvVars, k) {
+			return fmt.Errorf("thresholds file %s: line %d: expected KEY=value with a key of %s", path, n, strings.Join(thresholdEnvVars, ", "))
+		}
+		if err := os.Setenv(k, strings.TrimSpace(v)); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading STW_THRESHOLDS_FILE: %w", err)
+	}
+	return nil
+}
+
+// reloadThresholds re-reads STW_THRESHOLDS_FILE and the threshold env vars and
+// swaps them in, logging every value before and after. The current thresholds
+// are kept if the new ones are invalid, or would turn the quality tiers on or
+// off, whose instruments are only created at startup.
+func reloadThresholds() {
+	if err := applyThresholdsFile(settings.ThresholdsFile); err != nil {
+		log.Errorf("Keeping current thresholds: %v", err)
+		return
+	}
+	next, err := loadThresholds()
+	if err != nil {
+		log.Errorf("Keeping current thresholds: %v", err)
+		return
+	}
+	prev := thresholds()
+	if (prev.QualityGood == nil && prev.QualityOK == nil) != (next.QualityGood == nil && next.QualityOK == nil) {
+		log.Error("Keeping current thresholds: enabling or disabling STW_QUALITY_GOOD and STW_QUALITY_OK requires a restart")
+		return
+	}
+	activeThresholds.Store(&next)
+	before, after := prev.values(), next.values()
+	for _, k := range thresholdEnvVars {
+		if before[k] == after[k] {
+			log.Infof("Threshold %s unchanged: %s", k, after[k])
+		} else {
+			log.Infof("Threshold %s changed: %s -> %s", k, before[k], after[k])
+		}
+	}
+}
+
+// values formats each threshold by its env var, for the reload log.
+func (t *thresholdSettings) values() map[string]string {
+	bufferbloat := make([]string, len(t.BufferbloatThresholds))
+	for i, v := range t.BufferbloatThresholds {
+		bufferbloat[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return map[string]string{
+		"STW_BUFFERBLOAT_THRESHOLDS": strings.Join(bufferbloat, ","),
+		"STW_QUALITY_GOOD":           t.QualityGood.String(),
+		"STW_QUALITY_OK":             t.QualityOK.String(),
+		"STW_CRITICAL_THRESHOLDS":    t.CriticalThresholds.String(),
+		"STW_DEVIATION_PERCENT":      strconv.FormatFloat(t.DeviationPercent, 'g', -1, 64),
+		"STW_DEVIATION_ALERT":        strconv.FormatBool(t.DeviationAlert),
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestReloadThresholds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "thresholds.env")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The file sets these in the environment; t.Setenv restores them.
+	for _, k := range thresholdEnvVars {
+		t.Setenv(k, "")
+	}
+	write("# tuned\nSTW_QUALITY_GOOD=download=100\nSTW_DEVIATION_PERCENT=30\n")
+	useSettings(t, map[string]string{"STW_THRESHOLDS_FILE": path})
+	t.Cleanup(func() { activeThresholds.Store(nil) })
+	if got := thresholds().QualityGood.String(); got != "download=100" {
+		t.Fatalf("QualityGood at startup = %s", got)
+	}
+
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	log.SetLevel(log.InfoLevel)
+	defer log.SetLevel(log.WarnLevel)
+
+	// Readers racing the reload see either the old or the new thresholds.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Go(func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				qualityTier(WebhookPayload{Download: 150_000_000})
+			}
+		}
+	})
+	write("STW_QUALITY_GOOD=download=200\nSTW_DEVIATION_PERCENT=50\n")
+	reloadThresholds()
+	close(done)
+	wg.Wait()
+
+	if got := thresholds().QualityGood.String(); got != "download=200" {
+		t.Errorf("QualityGood after reload = %s, want download=200", got)
+	}
+	if got := thresholds().DeviationPercent; got != 50 {
+		t.Errorf("DeviationPercent after reload = %g, want 50", got)
+	}
+	var logged []string
+	for _, e := range hook.AllEntries() {
+		logged = append(logged, e.Message)
+	}
+	for _, want := range []string{
+		"Threshold STW_QUALITY_GOOD changed: download=100 -> download=200",
+		"Threshold STW_DEVIATION_PERCENT changed: 30 -> 50",
+		"Threshold STW_CRITICAL_THRESHOLDS unchanged: unset",
+	} {
+		if !strings.Contains(strings.Join(logged, "\n"), want) {
+			t.Errorf("reload log lacks %q, got %q", want, logged)
+		}
+	}
+
+	// Invalid values keep the current thresholds.
+	write("STW_DEVIATION_PERCENT=-1\n")
+	reloadThresholds()
+	if got := thresholds().DeviationPercent; got != 50 {
+		t.Errorf("DeviationPercent after invalid reload = %g, want 50", got)
+	}
+}
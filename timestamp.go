@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// resultTimestamp is the optional time a test ran. It unmarshals from an RFC 3339
+// string, a "2006-01-02 15:04:05" string in UTC, or a Unix timestamp in seconds.
+type resultTimestamp struct {
+	time.Time
+}
+
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+func (t *resultTimestamp) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] != '"' {
+		secs, err := strconv.ParseFloat(string(data), 64)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %s", data)
+		}
+		t.Time = time.Unix(0, int64(secs*float64(time.Second))).UTC()
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid timestamp %q", s)
+}
+
+// ingestDelayOf returns how long after the test ran its result arrived, from
+// the payload timestamp. Results without a timestamp, and those stamped in the
+// future by a skewed clock, have none.
+func ingestDelayOf(p WebhookPayload, received time.Time) (time.Duration, bool) {
+	if p.Timestamp == nil || p.Timestamp.IsZero() {
+		return 0, false
+	}
+	d := received.Sub(p.Timestamp.Time)
+	return d, d >= 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tlsVersions are the accepted STW_TLS_MIN_VERSION values. Anything older than
+// TLS 1.2 is deliberately not offered.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCipherSuites are the TLS 1.2 suites the server accepts: forward secret
+// AEAD ciphers only. TLS 1.3 suites are not configurable and always secure.
+var tlsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// loadTLSSettings reads the server certificate settings into s. TLS is enabled
+// when both STW_TLS_CERT_FILE and STW_TLS_KEY_FILE are set.
+func loadTLSSettings(s *Settings) error {
+	s.TLSCertFile = strings.TrimSpace(os.Getenv("STW_TLS_CERT_FILE"))
+	s.TLSKeyFile = strings.TrimSpace(os.Getenv("STW_TLS_KEY_FILE"))
+	if (s.TLSCertFile == "") != (s.TLSKeyFile == "") {
+		return fmt.Errorf("STW_TLS_CERT_FILE and STW_TLS_KEY_FILE must be set together")
+	}
+
+	raw := strings.TrimSpace(os.Getenv("STW_TLS_MIN_VERSION"))
+	if raw == "" {
+		raw = "1.2"
+	}
+	version, ok := tlsVersions[raw]
+	if !ok {
+		return fmt.Errorf("invalid value for env var STW_TLS_MIN_VERSION %s: must be 1.2 or 1.3", raw)
+	}
+	s.TLSMinVersion = version
+	return nil
+}
+
+// serverTLSConfig returns the TLS configuration for the listeners, or nil when
+// TLS is disabled.
+func serverTLSConfig() *tls.Config {
+	if settings.TLSCertFile == "" {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion:   settings.TLSMinVersion,
+		CipherSuites: tlsCipherSuites,
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// trackerClient is used for calls back to the Speedtest Tracker API.
+var trackerClient = &http.Client{Timeout: 15 * time.Second}
+
+// runTestHandler asks Speedtest Tracker to queue a new speedtest and relays the
+// upstream status and body. An optional server_id query parameter is forwarded.
+func runTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	logger := logFrom(r.Context())
+
+	target := strings.TrimSuffix(settings.TrackerAPIURL, "/") + "/api/v1/speedtests/run"
+	if serverID := r.URL.Query().Get("server_id"); serverID != "" {
+		target += "?" + url.Values{"server_id": {serverID}}.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target, nil)
+	if err != nil {
+		logger.Errorf("Could not build Speedtest Tracker request: %v", err)
+		http.Error(w, "Error building upstream request", http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+settings.TrackerAPIToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := trackerClient.Do(req)
+	if err != nil {
+		logger.Errorf("Speedtest Tracker API call failed: %v", err)
+		http.Error(w, "Error calling Speedtest Tracker API", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		logger.Warnf("Speedtest Tracker API returned %s", resp.Status)
+	} else {
+		logger.Info("Queued a speedtest via the Speedtest Tracker API")
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, io.LimitReader(resp.Body, 1<<20)); err != nil {
+		logger.Errorf("Could not relay Speedtest Tracker response: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// compileTransform compiles STW_TRANSFORM_EXPR. The expression sees the payload
+// fields by their JSON names and must return a map of the fields to replace,
+// e.g. {"download": download * 1.05}.
+func compileTransform(raw string) (*vm.Program, error) {
+	env, err := payloadEnv(WebhookPayload{})
+	if err != nil {
+		return nil, err
+	}
+	program, err := expr.Compile(raw, expr.Env(env), expr.AllowUndefinedVariables(), expr.AsKind(reflect.Map))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for env var STW_TRANSFORM_EXPR: %w", err)
+	}
+	return program, nil
+}
+
+// payloadEnv exposes a payload to expressions as a map keyed by JSON field name.
+// Optional fields that are absent are left undefined and evaluate to nil.
+func payloadEnv(p WebhookPayload) (map[string]any, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var env map[string]any
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// applyTransform runs program against p and returns the payload with the
+// returned fields replaced.
+func applyTransform(program *vm.Program, p WebhookPayload) (WebhookPayload, error) {
+	env, err := payloadEnv(p)
+	if err != nil {
+		return p, err
+	}
+	out, err := expr.Run(program, env)
+	if err != nil {
+		return p, err
+	}
+	changes, ok := out.(map[string]any)
+	if !ok {
+		return p, fmt.Errorf("transform returned %T, expected a map", out)
+	}
+	for k, v := range changes {
+		env[k] = v
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return p, err
+	}
+	var transformed WebhookPayload
+	if err := json.Unmarshal(data, &transformed); err != nil {
+		return p, fmt.Errorf("transform produced an invalid payload: %w", err)
+	}
+	transformed.Extra = p.Extra
+	return transformed, nil
+}